@@ -0,0 +1,134 @@
+package main
+
+// Post-extraction acceptance rules, to weed out things which look like
+// articles but aren't (live blogs, galleries, "page not found" pages
+// served with a 200 etc).
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/bcampbell/scrapeomat/discover"
+	"github.com/bcampbell/scrapeomat/store"
+	"golang.org/x/net/html"
+)
+
+// rejection reasons, as counted in ScrapeStats.Rejected
+const (
+	rejectRedirect = "redirected to non-article"
+	rejectShort    = "content too short"
+	rejectMissing  = "missing required field"
+	rejectHeadline = "headline matches xheadline"
+	rejectSection  = "section matches xsection"
+	rejectSelector = "page matches xartsel"
+)
+
+// rejectError is returned by ScrapeArt when a page is fetched OK, but
+// isn't accepted as an article.
+type rejectError struct {
+	artURL string
+	reason string // one of the reject* consts
+	detail string
+}
+
+func (e *rejectError) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", e.artURL, e.reason, e.detail)
+}
+
+// acceptRules decides whether scraped articles should be kept.
+type acceptRules struct {
+	minLength int
+	require   []string
+	xHeadline []*regexp.Regexp
+	xSection  []*regexp.Regexp
+	xSel      cascadia.Selector
+	xSelSrc   string
+}
+
+// fields which can be required
+var requirable = map[string]func(art *store.Article) bool{
+	"headline":  func(art *store.Article) bool { return strings.TrimSpace(art.Headline) != "" },
+	"published": func(art *store.Article) bool { return art.Published != "" },
+	"content":   func(art *store.Article) bool { return strings.TrimSpace(art.Content) != "" },
+	"authors":   func(art *store.Article) bool { return len(art.Authors) > 0 },
+	"section":   func(art *store.Article) bool { return art.Section != "" },
+}
+
+func buildAcceptRules(conf *ScraperConf) (*acceptRules, error) {
+	rules := &acceptRules{minLength: conf.MinLength}
+	for _, field := range conf.Require {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if _, got := requirable[field]; !got {
+			return nil, fmt.Errorf("bad require: unknown field '%s' (expected headline, published, content, authors or section)", field)
+		}
+		rules.require = append(rules.require, field)
+	}
+	var err error
+	rules.xHeadline, err = discover.BuildRegExps(conf.XHeadline)
+	if err != nil {
+		return nil, fmt.Errorf("bad xheadline: %s", err)
+	}
+	rules.xSection, err = discover.BuildRegExps(conf.XSection)
+	if err != nil {
+		return nil, fmt.Errorf("bad xsection: %s", err)
+	}
+	if conf.XArtSel != "" {
+		rules.xSel, err = cascadia.Compile(conf.XArtSel)
+		if err != nil {
+			return nil, fmt.Errorf("bad xartsel: %s", err)
+		}
+		rules.xSelSrc = conf.XArtSel
+	}
+	return rules, nil
+}
+
+// check applies the rules to a scraped article, returning the reason
+// and details if it should be rejected (or "" if it's acceptable).
+// rawHTML is the page the article was extracted from.
+func (rules *acceptRules) check(art *store.Article, rawHTML []byte) (string, string) {
+	for _, field := range rules.require {
+		if !requirable[field](art) {
+			return rejectMissing, field
+		}
+	}
+	if rules.minLength > 0 {
+		if n := textLength(art.Content); n < rules.minLength {
+			return rejectShort, fmt.Sprintf("%d chars", n)
+		}
+	}
+	for _, re := range rules.xHeadline {
+		if re.MatchString(art.Headline) {
+			return rejectHeadline, re.String()
+		}
+	}
+	for _, re := range rules.xSection {
+		if re.MatchString(art.Section) {
+			return rejectSection, re.String()
+		}
+	}
+	if rules.xSel != nil {
+		root, err := html.Parse(bytes.NewReader(rawHTML))
+		if err == nil && rules.xSel.MatchFirst(root) != nil {
+			return rejectSelector, rules.xSelSrc
+		}
+	}
+	return "", ""
+}
+
+// textLength returns the number of characters of text in a chunk of html
+func textLength(content string) int {
+	n := 0
+	z := html.NewTokenizer(strings.NewReader(content))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return n
+		case html.TextToken:
+			n += utf8.RuneCount(bytes.TrimSpace(z.Text()))
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bcampbell/scrapeomat/store"
+)
+
+func TestAcceptRules(t *testing.T) {
+	conf := &ScraperConf{
+		MinLength: 20,
+		Require:   []string{"headline", "Published"},
+		XHeadline: []string{`(?i)^live:`},
+		XSection:  []string{`^gallery$`},
+		XArtSel:   `body.error-page`,
+	}
+	rules, err := buildAcceptRules(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := []byte(`<html><body class="article"><h1>Hello</h1></body></html>`)
+	good := func() *store.Article {
+		return &store.Article{
+			Headline:  "Moon made of cheese",
+			Published: "2017-01-05",
+			Content:   "<p>Scientists are</p> <p>astonished.</p>",
+			Section:   "science",
+		}
+	}
+
+	testData := []struct {
+		mod    func(art *store.Article)
+		page   string
+		expect string
+	}{
+		{func(art *store.Article) {}, "", ""},
+		{func(art *store.Article) { art.Headline = " " }, "", rejectMissing},
+		{func(art *store.Article) { art.Published = "" }, "", rejectMissing},
+		{func(art *store.Article) { art.Content = "<p>Too short</p>" }, "", rejectShort},
+		{func(art *store.Article) { art.Headline = "LIVE: moon latest" }, "", rejectHeadline},
+		{func(art *store.Article) { art.Section = "gallery" }, "", rejectSection},
+		{func(art *store.Article) {}, `<html><body class="error-page"><h1>Not found</h1></body></html>`, rejectSelector},
+	}
+	for i, dat := range testData {
+		art := good()
+		dat.mod(art)
+		raw := page
+		if dat.page != "" {
+			raw = []byte(dat.page)
+		}
+		got, _ := rules.check(art, raw)
+		if got != dat.expect {
+			t.Errorf("%d: expected %q, got %q", i, dat.expect, got)
+		}
+	}
+
+	// no rules - anything goes
+	rules, err = buildAcceptRules(&ScraperConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := rules.check(&store.Article{}, nil); got != "" {
+		t.Errorf("empty rules rejected article (%s)", got)
+	}
+
+	if _, err := buildAcceptRules(&ScraperConf{Require: []string{"wibble"}}); err == nil {
+		t.Errorf("expected error for unknown required field")
+	}
+}
@@ -1,7 +1,13 @@
 package arc
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSpreadPath(t *testing.T) {
@@ -20,3 +26,90 @@ func TestSpreadPath(t *testing.T) {
 	}
 
 }
+
+func TestFindResponse(t *testing.T) {
+	warcDir, err := ioutil.TempDir("", "arctest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(warcDir)
+
+	srcURL := "http://example.com/news/moon-made-of-cheese"
+	finalURL, _ := url.Parse("http://example.com/news/2001/moon-made-of-cheese")
+	body := "<html><body><h1>Moon made of cheese</h1></body></html>"
+	resp := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    200,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/html"}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       &http.Request{Method: "GET", URL: finalURL},
+	}
+	fetched := time.Date(2017, 1, 5, 10, 0, 0, 0, time.UTC)
+
+	_, _, err = FindResponse(warcDir, srcURL)
+	if err != ErrNotArchived {
+		t.Fatalf("expected ErrNotArchived, got %v", err)
+	}
+
+	err = ArchiveResponse(warcDir, resp, srcURL, fetched)
+	if err != nil {
+		t.Fatalf("ArchiveResponse failed: %s", err)
+	}
+
+	got, timeStamp, err := FindResponse(warcDir, srcURL)
+	if err != nil {
+		t.Fatalf("FindResponse failed: %s", err)
+	}
+	defer got.Body.Close()
+	if !timeStamp.Equal(fetched) {
+		t.Errorf("wrong timestamp (got %s, expected %s)", timeStamp, fetched)
+	}
+	if got.StatusCode != 200 {
+		t.Errorf("wrong status (got %d)", got.StatusCode)
+	}
+	if got.Request.URL.String() != finalURL.String() {
+		t.Errorf("wrong final URL (got %s, expected %s)", got.Request.URL, finalURL)
+	}
+	gotBody, err := ioutil.ReadAll(got.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(gotBody) != body {
+		t.Errorf("body mismatch (got %q)", gotBody)
+	}
+
+	// now with a redirect
+	srcURL2 := "http://example.com/news/12345"
+	srcURL2Parsed, _ := url.Parse(srcURL2)
+	redir := &http.Response{
+		Status:     "301 Moved Permanently",
+		StatusCode: 301,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Location": {finalURL.String()}},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    &http.Request{Method: "GET", URL: srcURL2Parsed},
+	}
+	resp.Body = ioutil.NopCloser(strings.NewReader(body))
+	err = ArchiveResponses(warcDir, []*http.Response{redir, resp}, srcURL2, fetched)
+	if err != nil {
+		t.Fatalf("ArchiveResponses failed: %s", err)
+	}
+	got, _, err = FindResponse(warcDir, srcURL2)
+	if err != nil {
+		t.Fatalf("FindResponse failed: %s", err)
+	}
+	defer got.Body.Close()
+	if got.StatusCode != 200 || got.Request.URL.String() != finalURL.String() {
+		t.Errorf("expected final response, got %d %s", got.StatusCode, got.Request.URL)
+	}
+	prev := got.Request.Response
+	if prev == nil || prev.StatusCode != 301 || prev.Request.URL.String() != srcURL2 {
+		t.Errorf("redirect not linked in")
+	}
+}
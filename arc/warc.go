@@ -3,10 +3,15 @@ package arc
 // helpers to write out raw HTTP requests/responses to noddy .warc files
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/bcampbell/warc"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -30,35 +35,110 @@ func spreadPath(name string) string {
 	return path.Join(parts...)
 }
 
-/*
-func AlreadyGot(warcDir, srcURL string) bool,error {
+// ErrNotArchived is returned by FindResponse when there's no archived copy
+var ErrNotArchived = errors.New("not in archive")
+
+// returns the directory and filename used to archive srcURL
+func archivePath(warcDir string, srcURL string) (string, string, error) {
 	u, err := url.Parse(srcURL)
 	if err != nil {
-		return err
+		return "", "", err
 	}
+
 	hasher := md5.New()
 	hasher.Write([]byte(srcURL))
-	filename := hex.EncodeToString(hasher.Sum(nil)) + ".warc"
+	filename := hex.EncodeToString(hasher.Sum(nil)) + ".warc.gz"
+
+	//dir := path.Join(warcDir, u.Host, timeStamp.UTC().Format("2006-01-02"))
+
+	// .../www.example.com/1/12/123/12345678.warc
 	dir := path.Join(warcDir, u.Host, spreadPath(filename))
-    full := path.Join(dir, filename)
+	return dir, filename, nil
 }
-*/
 
-func ArchiveResponse(warcDir string, resp *http.Response, srcURL string, timeStamp time.Time) error {
+// FindResponse looks up the archived response for srcURL (as written
+// by ArchiveResponse), returning the response and the time it was fetched.
+// The returned response has its Request field set up, with the URL
+// holding the final URL (ie after any redirects).
+// If any redirect responses were archived along with it, they are linked
+// via Request.Response, as http.Client does.
+// If there's no archived copy, ErrNotArchived is returned.
+func FindResponse(warcDir string, srcURL string) (*http.Response, time.Time, error) {
+	dir, filename, err := archivePath(warcDir, srcURL)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
 
-	u, err := url.Parse(srcURL)
+	f, err := os.Open(path.Join(dir, filename))
+	if os.IsNotExist(err) {
+		return nil, time.Time{}, ErrNotArchived
+	}
 	if err != nil {
-		return err
+		return nil, time.Time{}, err
 	}
+	defer f.Close()
 
-	hasher := md5.New()
-	hasher.Write([]byte(srcURL))
-	filename := hex.EncodeToString(hasher.Sum(nil)) + ".warc.gz"
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer gzr.Close()
 
-	//dir := path.Join(warcDir, u.Host, timeStamp.UTC().Format("2006-01-02"))
+	var prev *http.Response
+	var prevTime time.Time
+	rdr := warc.NewReader(gzr)
+	for {
+		rec, err := rdr.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if rec.Header.Get("Warc-Type") != "response" {
+			continue
+		}
+		timeStamp, err := time.Parse(time.RFC3339, rec.Header.Get("Warc-Date"))
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("bad WARC-Date: %s", err)
+		}
 
-	// .../www.example.com/1/12/123/12345678.warc
-	dir := path.Join(warcDir, u.Host, spreadPath(filename))
+		// the response body is held in memory, so no need to keep the
+		// file open.
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(rec.Block)), nil)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		targetURL, err := url.Parse(rec.TargetURI())
+		if err != nil || targetURL.String() == "" {
+			targetURL, err = url.Parse(srcURL)
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+		}
+		resp.Request = &http.Request{Method: "GET", URL: targetURL, Header: http.Header{}, Response: prev}
+		prev, prevTime = resp, timeStamp
+	}
+	if prev == nil {
+		return nil, time.Time{}, ErrNotArchived
+	}
+	return prev, prevTime, nil
+}
+
+// ArchiveResponse writes a response out to a .warc file, filed under
+// srcURL (the URL originally requested).
+func ArchiveResponse(warcDir string, resp *http.Response, srcURL string, timeStamp time.Time) error {
+	return ArchiveResponses(warcDir, []*http.Response{resp}, srcURL, timeStamp)
+}
+
+// ArchiveResponses writes a chain of responses (eg any redirects followed,
+// then the final response) out to a single .warc file, filed under srcURL.
+func ArchiveResponses(warcDir string, resps []*http.Response, srcURL string, timeStamp time.Time) error {
+
+	dir, filename, err := archivePath(warcDir, srcURL)
+	if err != nil {
+		return err
+	}
 	err = os.MkdirAll(dir, 0777) // let umask cull the perms down...
 	if err != nil {
 		return err
@@ -73,5 +153,11 @@ func ArchiveResponse(warcDir string, resp *http.Response, srcURL string, timeSta
 	gzw := gzip.NewWriter(outfile)
 	defer gzw.Close()
 
-	return warc.Write(gzw, resp, srcURL, timeStamp)
+	for _, resp := range resps {
+		err = warc.Write(gzw, resp, srcURL, timeStamp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,278 @@
+package main
+
+// Config checking (the -check flag).
+// gcfg doesn't give line numbers for bad values or unknown keys, so we
+// scan the files ourselves to find where everything is, then check each
+// setting individually.
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bcampbell/scrapeomat/discover"
+	"github.com/bcampbell/scrapeomat/extract"
+	"gopkg.in/gcfg.v1"
+	"gopkg.in/gcfg.v1/scanner"
+	"gopkg.in/gcfg.v1/token"
+)
+
+// a variable in a config file
+type cfgVar struct {
+	name  string
+	raw   string // value, as it appears in the file
+	blank bool   // no value given
+	pos   token.Position
+}
+
+// a section in a config file
+type cfgSection struct {
+	name string
+	sub  string
+	pos  token.Position
+	vars []cfgVar
+}
+
+// scanConfig finds all the sections and variables in a config file.
+// It assumes the file has already been checked for syntax errors.
+func scanConfig(filename string, src []byte) []*cfgSection {
+	fset := token.NewFileSet()
+	file := fset.AddFile(filename, fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, 0)
+
+	sections := []*cfgSection{}
+	var sect *cfgSection
+	pos, tok, lit := s.Scan()
+	for tok != token.EOF {
+		switch tok {
+		case token.LBRACK:
+			sect = &cfgSection{pos: fset.Position(pos)}
+			sections = append(sections, sect)
+			_, tok, lit = s.Scan()
+			sect.name = lit
+			_, tok, lit = s.Scan()
+			if tok == token.STRING {
+				sect.sub = strings.Trim(lit, `"`)
+			}
+			// skip the rest of the line
+			for tok != token.EOL && tok != token.EOF {
+				_, tok, lit = s.Scan()
+			}
+		case token.IDENT:
+			v := cfgVar{name: lit, pos: fset.Position(pos), blank: true}
+			_, tok, lit = s.Scan()
+			if tok == token.ASSIGN {
+				_, tok, lit = s.Scan()
+				v.raw = lit
+				v.blank = false
+			}
+			if sect != nil {
+				sect.vars = append(sect.vars, v)
+			}
+			for tok != token.EOL && tok != token.EOF {
+				_, tok, lit = s.Scan()
+			}
+		default:
+			pos, tok, lit = s.Scan()
+		}
+		if tok == token.EOL {
+			pos, tok, lit = s.Scan()
+		}
+	}
+	return sections
+}
+
+// checkProblem is a problem found in a config file.
+type checkProblem struct {
+	pos token.Position
+	msg string
+}
+
+func (p checkProblem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.pos.Filename, p.pos.Line, p.msg)
+}
+
+// checkConfigs checks all the scraper config files in dir, writing out any
+// problems found. Returns the number of problems.
+// Some things (eg scrapers sharing a pubcode) are legitimate, but
+// suspicious enough to warrant a warning. These aren't counted as problems.
+func checkConfigs(dir string, w io.Writer) (int, error) {
+	configFiles, err := filepath.Glob(path.Join(dir, "*.cfg"))
+	if err != nil {
+		return 0, err
+	}
+	if configFiles == nil {
+		return 0, fmt.Errorf("no scraper config files found (in \"%s\")", dir)
+	}
+
+	problems := []checkProblem{}
+	report := func(pos token.Position, format string, args ...interface{}) {
+		p := checkProblem{pos: pos, msg: fmt.Sprintf(format, args...)}
+		problems = append(problems, p)
+		fmt.Fprintln(w, p.String())
+	}
+	warnCnt := 0
+	warn := func(pos token.Position, format string, args ...interface{}) {
+		p := checkProblem{pos: pos, msg: "warning: " + fmt.Sprintf(format, args...)}
+		warnCnt++
+		fmt.Fprintln(w, p.String())
+	}
+
+	names := map[string]token.Position{}    // scraper name -> where defined
+	pubCodes := map[string]token.Position{} // pubcode -> where defined
+	scraperCnt := 0
+	for _, fileName := range configFiles {
+		// first, let gcfg check the syntax
+		cfg := struct {
+			Scraper map[string]*ScraperConf
+		}{}
+		err := gcfg.FatalOnly(gcfg.ReadFileInto(&cfg, fileName))
+		if err != nil && !strings.Contains(err.Error(), " at section ") {
+			// (gcfg syntax errors already include file:line:col)
+			fmt.Fprintln(w, err)
+			problems = append(problems, checkProblem{msg: err.Error()})
+			continue
+		}
+
+		src, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return 0, err
+		}
+		for _, sect := range scanConfig(fileName, src) {
+			if !strings.EqualFold(sect.name, "scraper") {
+				report(sect.pos, "unknown section '%s'", sect.name)
+				continue
+			}
+			scraperCnt++
+			if first, got := names[sect.sub]; got {
+				report(sect.pos, "duplicate scraper '%s' (already defined at %s:%d)", sect.sub, first.Filename, first.Line)
+				continue
+			}
+			names[sect.sub] = sect.pos
+
+			sectOK := true
+			pubCode, pubCodePos := sect.sub, sect.pos
+			gotURL := false
+			for _, v := range sect.vars {
+				msg := checkConfVar(sect.sub, v)
+				if msg != "" {
+					report(v.pos, "%s", msg)
+					sectOK = false
+				}
+				switch strings.ToLower(v.name) {
+				case "pubcode":
+					pubCode, pubCodePos = strings.Trim(v.raw, `"`), v.pos
+				case "url":
+					gotURL = true
+				}
+			}
+			if !gotURL {
+				report(sect.pos, "scraper '%s' has no url", sect.sub)
+				sectOK = false
+			}
+			if conf, got := cfg.Scraper[sect.sub]; got && conf.SitemapOnly && len(conf.Sitemap) == 0 {
+				report(sect.pos, "scraper '%s' has sitemaponly set, but no sitemap", sect.sub)
+				sectOK = false
+			}
+			if first, got := pubCodes[pubCode]; got {
+				warn(pubCodePos, "duplicate pubcode '%s' (already used at %s:%d)", pubCode, first.Filename, first.Line)
+			} else {
+				pubCodes[pubCode] = pubCodePos
+			}
+
+			// try building the whole thing, to catch anything else
+			if conf, got := cfg.Scraper[sect.sub]; got && sectOK {
+				_, err := NewScraper(sect.sub, conf, 0, "", "")
+				if err != nil {
+					report(sect.pos, "%s", err)
+				}
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		fmt.Fprintf(w, "OK (%d scrapers in %d files, %d warnings)\n", scraperCnt, len(configFiles), warnCnt)
+	}
+	return len(problems), nil
+}
+
+// checkConfVar checks a single config variable, returning a description
+// of the problem (or "" if it's OK).
+func checkConfVar(scraperName string, v cfgVar) string {
+	// parse it in isolation
+	src := fmt.Sprintf("[scraper %q]\n%s", scraperName, v.name)
+	if !v.blank {
+		src += " = " + v.raw
+	}
+	cfg := struct {
+		Scraper map[string]*ScraperConf
+	}{}
+	err := gcfg.ReadStringInto(&cfg, src)
+	if err != nil {
+		if gcfg.FatalOnly(err) == nil {
+			return fmt.Sprintf("unknown key '%s'", v.name)
+		}
+		// trim off gcfg's location info - we've got a better one
+		msg := err.Error()
+		if idx := strings.Index(msg, " at section "); idx != -1 {
+			msg = msg[:idx]
+		}
+		return fmt.Sprintf("%s: %s", v.name, msg)
+	}
+	conf := cfg.Scraper[scraperName]
+	if err := validateConf(conf); err != nil {
+		msg := err.Error()
+		if !strings.Contains(strings.ToLower(msg), strings.ToLower(v.name)) {
+			msg = v.name + ": " + msg
+		}
+		return msg
+	}
+	return ""
+}
+
+// validateConf checks the values in a scraper config, compiling any
+// patterns, selectors etc.
+func validateConf(conf *ScraperConf) error {
+	if conf.Type != "" {
+		if _, got := scraperTypes[conf.Type]; !got {
+			return fmt.Errorf("unknown scraper type '%s' (known types: %v)", conf.Type, ScraperTypes())
+		}
+	}
+	if _, err := discover.NewDiscoverer(conf.DiscovererDef); err != nil {
+		return err
+	}
+	if _, err := NewSchedule(conf); err != nil {
+		return err
+	}
+	if _, err := buildRetryPolicy(conf); err != nil {
+		return err
+	}
+	if _, err := buildTransport(conf); err != nil {
+		return err
+	}
+	if _, err := buildHeaders(conf); err != nil {
+		return err
+	}
+	if _, err := extract.NewOverride(conf.OverrideDef); err != nil {
+		return err
+	}
+	if _, err := buildAcceptRules(conf); err != nil {
+		return err
+	}
+	if conf.Timezone != "" {
+		if _, err := time.LoadLocation(conf.Timezone); err != nil {
+			return fmt.Errorf("bad timezone: %s", err)
+		}
+	}
+	if conf.ArchiveMaxAge != "" {
+		if _, err := time.ParseDuration(conf.ArchiveMaxAge); err != nil {
+			return fmt.Errorf("bad archivemaxage: %s", err)
+		}
+	}
+	return nil
+}
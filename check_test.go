@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checktest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.cfg": `[scraper "foo"]
+url="http://example.com"
+navsel="a[[["
+artpat="(unclosed"
+wibble=1
+
+[scraper "bar"]
+url="http://example.com"
+pubcode="foo"
+
+[scraper "baz"]
+url="http://example.com"
+sitemaponly=true
+sitemapmaxage=soon
+`,
+		"b.cfg": `# comment
+[scraper "foo"]
+url="http://example.com"
+`,
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	cnt, err := checkConfigs(dir, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"a.cfg:3: navsel:",
+		"a.cfg:4: artpat:",
+		"a.cfg:5: unknown key 'wibble'",
+		"a.cfg:9: warning: duplicate pubcode 'foo'",
+		"a.cfg:11: scraper 'baz' has sitemaponly set, but no sitemap",
+		"a.cfg:14: bad sitemapmaxage",
+		"b.cfg:2: duplicate scraper 'foo'",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(out.String(), exp) {
+			t.Errorf("expected %q in output:\n%s", exp, out.String())
+		}
+	}
+	if cnt != 6 {
+		t.Errorf("expected 6 problems, got %d", cnt)
+	}
+}
@@ -98,6 +98,7 @@ func FancyStash(db store.Store, updateExisting bool, arts ...*store.Article) err
 	updateArts := []*store.Article{} // contains subset of stashArts
 	skipArts := []*store.Article{}
 	badArts := []*store.Article{}
+	addedURLs := 0 // extra urls added to skipped articles
 
 	for _, art := range arts {
 		err := SanityCheckArticle(art)
@@ -113,6 +114,9 @@ func FancyStash(db store.Store, updateExisting bool, arts ...*store.Article) err
 		}
 		urls = append(urls, art.URLs...)
 		ids, err := db.FindURLs(urls)
+		if err != nil {
+			return err
+		}
 		if len(ids) == 0 {
 			// not in DB - it's new.
 			stashArts = append(stashArts, art)
@@ -126,7 +130,12 @@ func FancyStash(db store.Store, updateExisting bool, arts ...*store.Article) err
 				stashArts = append(stashArts, art)
 				updateArts = append(updateArts, art)
 			} else {
-				// skip it.
+				// skip it (but make sure we've got all its URLs).
+				added, err := db.AddURLs(art.ID, urls)
+				if err != nil {
+					return err
+				}
+				addedURLs += added
 				skipArts = append(skipArts, art)
 				continue
 			}
@@ -144,7 +153,7 @@ func FancyStash(db store.Store, updateExisting bool, arts ...*store.Article) err
 	if err != nil {
 		return err
 	}
-	fmt.Fprintf(os.Stderr, "%d stashed (%d updated), %d skipped, %d bad\n", len(stashArts), len(updateArts), len(skipArts), len(badArts))
+	fmt.Fprintf(os.Stderr, "%d stashed (%d updated), %d skipped (%d urls added), %d bad\n", len(stashArts), len(updateArts), len(skipArts), addedURLs, len(badArts))
 
 	return nil
 }
@@ -174,6 +183,10 @@ func convertArticle(src *Art) *store.Article {
 		out.Authors = append(out.Authors, store.Author{Name: src.Byline})
 	}
 
+	if opts.loc != nil {
+		out.ResolveTimezone(opts.loc)
+	}
+
 	// fill in pubcode if missing
 	if out.Publication.Code == "" {
 		if src.Pubcode != "" {
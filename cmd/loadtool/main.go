@@ -7,9 +7,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"strings"
-	//"time"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/bcampbell/scrapeomat/store"
 	"github.com/bcampbell/scrapeomat/store/sqlstore"
@@ -40,6 +40,8 @@ var opts struct {
 	htmlEscape       bool
 	recursive        bool
 	forceUpdate      bool
+	tz               string
+	loc              *time.Location
 }
 
 const usageTxt = `usage: loadtool [options] [file(s)]>
@@ -63,6 +65,7 @@ func main() {
 	flag.BoolVar(&opts.forceUpdate, "f", false, "force update of articles already in db")
 	flag.StringVar(&opts.pubCode, "pubcode", "", "publication shortcode (if not in article data)")
 	flag.BoolVar(&opts.htmlEscape, "e", false, "HTML-escape plain text content field")
+	flag.StringVar(&opts.tz, "tz", "", "timezone for dates which don't specify one (eg \"Europe/London\", default UTC)")
 	flag.Parse()
 
 	if flag.NArg() < 1 {
@@ -70,6 +73,15 @@ func main() {
 		os.Exit(1)
 	}
 
+	if opts.tz != "" {
+		var err error
+		opts.loc, err = time.LoadLocation(opts.tz)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: bad timezone: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
 	jsonFiles, err := collectFiles(flag.Args(), opts.recursive)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
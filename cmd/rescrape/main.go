@@ -7,12 +7,15 @@ package main
 // Uses multiple CPU cores if available.
 //
 // caveats:
-// it assumes that each .warc file contains a simple request/response
-// arrangment and doesn't (yet) do anything clever to collect redirects.
+// it assumes that each .warc file contains a simple sequence of responses
+// (any redirects followed, then the final response).
 // The initial purpose is to rescrape using the simple .warc files archived
 // by scrapeomat.
 // Needs some work to generalise it to more complicated .warc arrangements.
 
+//
+// If scraper configs are given (-s), any extraction overrides (headlinesel,
+// contentsel etc) for the matching scraper are applied.
 //
 // TODO:
 // use scraper configs to apply URL rejection rules + whatever other metadata (eg publication codes)
@@ -30,6 +33,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
@@ -50,7 +54,7 @@ func worker(db store.Store, fileChan chan string, wg *sync.WaitGroup) {
 
 // scrape a .warc file, stash result in db
 func process(db store.Store, f string) {
-	scraped, err := fromWARC(f)
+	scraped, err := fromWARC(f, sites)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s FAILED: %s\n", f, err)
 		return
@@ -60,6 +64,9 @@ func process(db store.Store, f string) {
 	//fmt.Printf("stash %s: %v", f, art.URLs)
 
 	art := store.ConvertArticle(scraped)
+	if opts.loc != nil {
+		art.ResolveTimezone(opts.loc)
+	}
 
 	//	fmt.Println(art.Published)
 
@@ -75,6 +82,18 @@ func process(db store.Store, f string) {
 
 	alreadyGot := (len(artIDs) > 0)
 	if alreadyGot && !opts.forceReplace {
+		if len(artIDs) == 1 {
+			// make sure it's findable by all its urls
+			added, err := db.AddURLs(artIDs[0], art.URLs)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: AddURLs() FAILED: %s\n", f, err)
+				return
+			}
+			if added > 0 {
+				fmt.Fprintf(os.Stdout, "%s : ADDED %d URLS TO %d '%s'\n", f, added, artIDs[0], art.Headline)
+				return
+			}
+		}
 		fmt.Fprintf(os.Stderr, "got %s already (id %d)\n", art.URLs[0], artIDs)
 		return
 	}
@@ -121,6 +140,11 @@ var opts struct {
 	db           string
 	driver       string
 	forceReplace bool
+	tz           string
+	loc          *time.Location
+	// scraper configs, for extraction overrides
+	scraperConfigPath string
+	scraperName       string
 }
 
 func main() {
@@ -133,6 +157,9 @@ func main() {
 	flag.StringVar(&opts.driver, "driver", "", "database driver (defaults to sqlite3 if SCRAPEOMAT_DRIVER is not set)")
 	flag.StringVar(&opts.db, "db", "", "database connection string")
 	flag.BoolVar(&opts.forceReplace, "f", false, "force replacement of articles already in db")
+	flag.StringVar(&opts.tz, "tz", "", "timezone for dates which don't specify one (eg \"Europe/London\", default UTC)")
+	flag.StringVar(&opts.scraperConfigPath, "s", "", "path for scraper configs, to apply extraction overrides (default none)")
+	flag.StringVar(&opts.scraperName, "scraper", "", "use the overrides from this scraper for all files (default is to pick by URL)")
 	flag.Parse()
 
 	if flag.NArg() < 1 {
@@ -140,6 +167,27 @@ func main() {
 		os.Exit(1)
 	}
 
+	if opts.tz != "" {
+		var err error
+		opts.loc, err = time.LoadLocation(opts.tz)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: bad timezone: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if opts.scraperConfigPath != "" {
+		var err error
+		sites, err = loadSites(opts.scraperConfigPath, opts.scraperName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+	} else if opts.scraperName != "" {
+		fmt.Fprintf(os.Stderr, "ERROR: -scraper needs -s\n")
+		os.Exit(1)
+	}
+
 	db, err := sqlstore.NewWithEnv(opts.driver, opts.db)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
@@ -177,7 +225,7 @@ func main() {
 }
 
 // TODO: this is from arts/scrapetool. Make sure to replicate any improvements there.
-func fromWARC(filename string) (*arts.Article, error) {
+func fromWARC(filename string, sites []*site) (*arts.Article, error) {
 	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
@@ -196,6 +244,8 @@ func fromWARC(filename string) (*arts.Article, error) {
 		in = f
 	}
 
+	// urls of any redirects leading to the article
+	hops := []string{}
 	warcReader := warc.NewReader(in)
 	for {
 		//	fmt.Printf("WARC\n")
@@ -214,6 +264,10 @@ func fromWARC(filename string) (*arts.Article, error) {
 			return nil, fmt.Errorf("Error parsing response: %s", err)
 		}
 		defer response.Body.Close()
+		if response.StatusCode >= 300 && response.StatusCode < 400 {
+			hops = append(hops, reqURL)
+			continue
+		}
 		if response.StatusCode != 200 {
 			return nil, fmt.Errorf("HTTP error: %d", response.StatusCode)
 		}
@@ -222,7 +276,36 @@ func fromWARC(filename string) (*arts.Article, error) {
 			return nil, err
 		}
 		// TODO: arts should allow passing in raw response? or header + body?
-		return arts.ExtractFromHTML(rawHTML, reqURL)
+		art, err := arts.ExtractFromHTML(rawHTML, reqURL)
+		if err != nil {
+			return nil, err
+		}
+		if s := pickSite(sites, reqURL); s != nil {
+			err = s.override.Apply(art, rawHTML, reqURL)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// include the url originally requested, and everything in between
+		srcURL := rec.Header.Get("X-Scrapeomat-Srcurl")
+		if srcURL != "" && (len(hops) == 0 || hops[0] != srcURL) {
+			hops = append([]string{srcURL}, hops...)
+		}
+		for _, u := range append(hops, reqURL) {
+			if !hasString(art.URLs, u) {
+				art.URLs = append(art.URLs, u)
+			}
+		}
+		return art, nil
 	}
 
 }
+
+func hasString(strs []string, s string) bool {
+	for _, x := range strs {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
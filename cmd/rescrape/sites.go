@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/bcampbell/scrapeomat/discover"
+	"github.com/bcampbell/scrapeomat/extract"
+	"gopkg.in/gcfg.v1"
+)
+
+// site holds the bits of a scraper config we use
+type site struct {
+	name     string
+	disc     *discover.Discoverer
+	override *extract.Override
+}
+
+// the sites loaded from the scraper configs (empty if none)
+var sites []*site
+
+// siteConf picks out the parts of a scraper config we're interested in
+// (the rest is ignored).
+type siteConf struct {
+	discover.DiscovererDef
+	extract.OverrideDef
+}
+
+// loadSites reads the scraper configs in dir. If only is set, just that
+// scraper is loaded.
+func loadSites(dir string, only string) ([]*site, error) {
+	cfg := struct {
+		Scraper map[string]*siteConf
+	}{}
+	configFiles, err := filepath.Glob(path.Join(dir, "*.cfg"))
+	if err != nil {
+		return nil, err
+	}
+	if configFiles == nil {
+		return nil, fmt.Errorf("no scraper config files found (in \"%s\")", dir)
+	}
+	for _, fileName := range configFiles {
+		// (ignore unknown keys - they're for the scraper)
+		err = gcfg.FatalOnly(gcfg.ReadFileInto(&cfg, fileName))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	names := []string{}
+	for name, _ := range cfg.Scraper {
+		if only == "" || name == only {
+			names = append(names, name)
+		}
+	}
+	if only != "" && len(names) == 0 {
+		return nil, fmt.Errorf("unknown scraper '%s'", only)
+	}
+	sort.Strings(names)
+
+	out := []*site{}
+	for _, name := range names {
+		conf := cfg.Scraper[name]
+		disc, err := discover.NewDiscoverer(conf.DiscovererDef)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		override, err := extract.NewOverride(conf.OverrideDef)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		out = append(out, &site{name: name, disc: disc, override: override})
+	}
+	return out, nil
+}
+
+// pickSite returns the site an article URL belongs to (nil if none).
+// If there's only one site (ie -scraper was used), it's always picked.
+func pickSite(sites []*site, artURL string) *site {
+	if len(sites) == 1 && opts.scraperName != "" {
+		return sites[0]
+	}
+	for _, s := range sites {
+		if _, err := s.disc.CookArticleURL(&s.disc.StartURL, artURL); err == nil {
+			return s
+		}
+	}
+	return nil
+}
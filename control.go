@@ -0,0 +1,193 @@
+package main
+
+// HTTP API for controlling a running scrapeomat (see the -control flag).
+//
+//	GET  /scrapers                  list scrapers and their state
+//	GET  /scrapers/NAME             state of a single scraper
+//	POST /scrapers/NAME/run         start a run now
+//	POST /scrapers/NAME/pause       don't start any more runs...
+//	POST /scrapers/NAME/resume      ...until resumed
+//	POST /scrapers/NAME/stop        stop the scraper for good
+//	POST /scrapers/NAME/urls        scrape a list of URLs (one per line
+//	                                in the request body). Add "?update=1"
+//	                                to update articles already in the db.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type controlServer struct {
+	d *daemon
+}
+
+// ScraperStatus describes a scraper, as returned by the control API.
+type ScraperStatus struct {
+	Name         string     `json:"name"`
+	State        string     `json:"state"`
+	LastRunStart *time.Time `json:"last_run_start,omitempty"`
+	LastRunEnd   *time.Time `json:"last_run_end,omitempty"`
+	LastRunOK    bool       `json:"last_run_ok"`
+	NextRun      *time.Time `json:"next_run,omitempty"`
+}
+
+func optTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// listenControl starts serving the control API on addr, which is either a
+// TCP address (eg "localhost:9101") or "unix:PATH" for a unix socket.
+func listenControl(addr string, d *daemon) error {
+	var l net.Listener
+	var err error
+	if strings.HasPrefix(addr, "unix:") {
+		sockPath := strings.TrimPrefix(addr, "unix:")
+		// zap any stale socket left from a previous run
+		os.Remove(sockPath)
+		l, err = net.Listen("unix", sockPath)
+	} else {
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	go func() {
+		err := http.Serve(l, &controlServer{d: d})
+		fmt.Fprintf(os.Stderr, "ERROR: control server: %s\n", err)
+	}()
+	return nil
+}
+
+func (cs *controlServer) status(name string, scraper Scraper) ScraperStatus {
+	st := ScraperStatus{Name: name, State: "unknown"}
+	if c, ok := scraper.(Controllable); ok {
+		st.State = c.State()
+	}
+	if sm, got := metrics.Get(name); got {
+		st.LastRunStart = optTime(sm.LastRunStart)
+		st.LastRunEnd = optTime(sm.LastRunEnd)
+		st.LastRunOK = sm.LastRunOK
+		st.NextRun = optTime(sm.NextRun)
+	}
+	return st
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func (cs *controlServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "scrapers" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+			return
+		}
+		out := []ScraperStatus{}
+		for _, name := range cs.d.names() {
+			if scraper, got := cs.d.get(name); got {
+				out = append(out, cs.status(name, scraper))
+			}
+		}
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+
+	name := parts[1]
+	scraper, got := cs.d.get(name)
+	if !got {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown scraper '%s'", name))
+		return
+	}
+
+	if len(parts) == 2 {
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+			return
+		}
+		writeJSON(w, http.StatusOK, cs.status(name, scraper))
+		return
+	}
+
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	action := parts[2]
+	switch action {
+	case "stop":
+		if err := cs.d.stop(name); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+	case "urls":
+		artURLs := []string{}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" {
+				artURLs = append(artURLs, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if len(artURLs) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("no URLs given"))
+			return
+		}
+		update := r.URL.Query().Get("update")
+		err := cs.d.runList(name, artURLs, update != "" && update != "0")
+		if err != nil {
+			// (eg scraper has been stopped)
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]int{"queued": len(artURLs)})
+		return
+	case "run", "pause", "resume":
+		c, ok := scraper.(Controllable)
+		if !ok {
+			writeError(w, http.StatusNotImplemented, fmt.Errorf("scraper '%s' doesn't support %s", name, action))
+			return
+		}
+		switch action {
+		case "run":
+			if err := c.RunNow(); err != nil {
+				writeError(w, http.StatusConflict, err)
+				return
+			}
+		case "pause":
+			c.Pause()
+		case "resume":
+			c.Resume()
+		}
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action '%s'", action))
+		return
+	}
+	writeJSON(w, http.StatusOK, cs.status(name, scraper))
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bcampbell/scrapeomat/store"
+)
+
+// ctlScraper is a fake Controllable scraper. It just sleeps until asked
+// to run, and passes on any lists of URLs it's given.
+type ctlScraper struct {
+	*fakeScraper
+	lock    sync.Mutex
+	busy    bool // pretend a run is in progress
+	paused  bool
+	nextRun time.Time
+	lists   chan []string
+}
+
+func newCtlScraper(name string) *ctlScraper {
+	return &ctlScraper{fakeScraper: newFakeScraper(name), lists: make(chan []string, 10)}
+}
+
+func (s *ctlScraper) State() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	select {
+	case <-s.quit:
+		return "stopped"
+	default:
+	}
+	switch {
+	case s.busy:
+		return "scraping"
+	case s.paused:
+		return "paused"
+	default:
+		return "sleeping"
+	}
+}
+
+func (s *ctlScraper) RunNow() error {
+	if state := s.State(); state != "sleeping" {
+		return fmt.Errorf("can't run (scraper is %s)", state)
+	}
+	return nil
+}
+
+func (s *ctlScraper) setBusy(busy bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.busy = busy
+}
+
+func (s *ctlScraper) Pause() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.paused = true
+}
+
+func (s *ctlScraper) Resume() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.paused = false
+}
+
+func (s *ctlScraper) Paused() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.paused
+}
+
+func (s *ctlScraper) NextRun() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.nextRun
+}
+
+func (s *ctlScraper) SetNextRun(t time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.nextRun = t
+}
+
+func (s *ctlScraper) DoRunFromList(arts []string, db store.Store, updateMode bool) error {
+	s.lists <- arts
+	return nil
+}
+
+func TestControlAPI(t *testing.T) {
+	d := newDaemon(nil)
+	foo := newCtlScraper("foo")
+	d.start(foo, &ScraperConf{})
+	d.start(newFakeScraper("plain"), &ScraperConf{}) // not Controllable
+	defer func() {
+		d.stopAll()
+		d.wait()
+	}()
+
+	srv := httptest.NewServer(&controlServer{d: d})
+	defer srv.Close()
+
+	do := func(method string, path string, body string) (int, string) {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		out, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(out)
+	}
+
+	testData := []struct {
+		method      string
+		path        string
+		body        string
+		expectCode  int
+		expectState string // (if a single scraper is returned)
+	}{
+		{"GET", "/scrapers/foo", "", 200, "sleeping"},
+		{"GET", "/scrapers/plain", "", 200, "unknown"},
+		{"POST", "/scrapers/foo/pause", "", 200, "paused"},
+		{"POST", "/scrapers/foo/run", "", 409, ""},
+		{"POST", "/scrapers/foo/resume", "", 200, "sleeping"},
+		{"POST", "/scrapers/foo/run", "", 200, "sleeping"},
+		{"POST", "/scrapers/plain/run", "", 501, ""},
+		{"POST", "/scrapers/foo/urls", "", 400, ""},
+		{"POST", "/scrapers/foo/urls", "\n  \n", 400, ""},
+		{"POST", "/scrapers/foo/wibble", "", 404, ""},
+		{"GET", "/scrapers/foo/run", "", 405, ""},
+		{"POST", "/scrapers", "", 405, ""},
+		{"PUT", "/scrapers/foo", "", 405, ""},
+		{"GET", "/scrapers/wibble", "", 404, ""},
+		{"POST", "/scrapers/wibble/run", "", 404, ""},
+		{"POST", "/scrapers/wibble/stop", "", 404, ""},
+		{"GET", "/wibble", "", 404, ""},
+		{"GET", "/scrapers/foo/run/now", "", 404, ""},
+	}
+	for _, dat := range testData {
+		code, body := do(dat.method, dat.path, dat.body)
+		if code != dat.expectCode {
+			t.Errorf("%s %s: expected %d, got %d (%s)", dat.method, dat.path, dat.expectCode, code, body)
+			continue
+		}
+		if dat.expectState != "" {
+			var st ScraperStatus
+			if err := json.Unmarshal([]byte(body), &st); err != nil {
+				t.Errorf("%s %s: bad json: %s", dat.method, dat.path, err)
+			} else if st.State != dat.expectState {
+				t.Errorf("%s %s: expected state %s, got %s", dat.method, dat.path, dat.expectState, st.State)
+			}
+		}
+	}
+
+	// can't run while a run is in progress
+	foo.setBusy(true)
+	if code, body := do("POST", "/scrapers/foo/run", ""); code != 409 {
+		t.Errorf("run while busy: expected 409, got %d (%s)", code, body)
+	}
+	foo.setBusy(false)
+
+	// list
+	code, body := do("GET", "/scrapers", "")
+	var list []ScraperStatus
+	if code != 200 || json.Unmarshal([]byte(body), &list) != nil || len(list) != 2 || list[0].Name != "foo" || list[1].Name != "plain" {
+		t.Errorf("list: expected foo and plain, got %d %s", code, body)
+	}
+
+	// urls
+	code, body = do("POST", "/scrapers/foo/urls?update=1", "http://example.com/1\n\n http://example.com/2 \n")
+	if code != 202 {
+		t.Errorf("urls: expected 202, got %d (%s)", code, body)
+	}
+	select {
+	case arts := <-foo.lists:
+		if strings.Join(arts, ",") != "http://example.com/1,http://example.com/2" {
+			t.Errorf("urls: got %v", arts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("urls: list wasn't run")
+	}
+
+	// stop - stays in the list, but won't do anything more
+	if code, body := do("POST", "/scrapers/foo/stop", ""); code != 200 {
+		t.Errorf("stop: expected 200, got %d (%s)", code, body)
+	}
+	if code, body := do("GET", "/scrapers/foo", ""); code != 200 || !strings.Contains(body, `"stopped"`) {
+		t.Errorf("after stop: expected stopped, got %d (%s)", code, body)
+	}
+	if code, body := do("POST", "/scrapers/foo/urls", "http://example.com/3"); code != 409 {
+		t.Errorf("urls after stop: expected 409, got %d (%s)", code, body)
+	}
+	if code, body := do("POST", "/scrapers/foo/run", ""); code != 409 {
+		t.Errorf("run after stop: expected 409, got %d (%s)", code, body)
+	}
+}
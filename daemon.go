@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bcampbell/scrapeomat/store"
+)
+
+// daemon looks after the scrapers when running continuously.
+type daemon struct {
+	db       store.Store
+	lock     sync.Mutex
+	entries  map[string]*daemonEntry
+	stopping bool
+	wg       sync.WaitGroup
+	// done channels of scrapers removed by reload, which might still be
+	// finishing up (by name)
+	removed map[string]chan struct{}
+}
+
+type daemonEntry struct {
+	scraper Scraper
+	conf    *ScraperConf
+	done    chan struct{} // closed when Start() returns
+	// replacement waiting for scraper to finish its current run (if any)
+	next *daemonEntry
+	// stopped via the control API (so don't bring it back on reload)
+	stopped bool
+}
+
+func newDaemon(db store.Store) *daemon {
+	return &daemon{
+		db:      db,
+		entries: map[string]*daemonEntry{},
+		removed: map[string]chan struct{}{},
+	}
+}
+
+// start adds a scraper and starts it running.
+func (d *daemon) start(scraper Scraper, conf *ScraperConf) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.launch(&daemonEntry{scraper: scraper, conf: conf}, nil)
+}
+
+// launch starts an entry running (lock must be held). If after is set, the
+// scraper isn't started until it's closed (eg so it can't overlap with an
+// old scraper of the same name which is still finishing).
+func (d *daemon) launch(e *daemonEntry, after <-chan struct{}) {
+	e.done = make(chan struct{})
+	name := e.scraper.Name()
+	d.entries[name] = e
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer close(e.done)
+		if after != nil {
+			<-after
+			d.lock.Lock()
+			cancelled := d.stopping || e.stopped || d.entries[name] != e
+			d.lock.Unlock()
+			if cancelled {
+				return
+			}
+		}
+		e.scraper.Start(d.db)
+	}()
+}
+
+// get looks up a scraper by name.
+func (d *daemon) get(name string) (Scraper, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	e, got := d.entries[name]
+	if !got {
+		return nil, false
+	}
+	return e.scraper, true
+}
+
+// names returns the names of all the scrapers, sorted.
+func (d *daemon) names() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	names := make([]string, 0, len(d.entries))
+	for name, _ := range d.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stop stops the named scraper at the operator's request. It stays in
+// the list (as "stopped"), and isn't restarted by reloads.
+func (d *daemon) stop(name string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	e, got := d.entries[name]
+	if !got {
+		return fmt.Errorf("unknown scraper '%s'", name)
+	}
+	e.stopped = true
+	e.next = nil
+	e.scraper.Stop()
+	return nil
+}
+
+// runList scrapes a list of URLs using the named scraper, in the
+// background.
+func (d *daemon) runList(name string, artURLs []string, updateMode bool) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	e, got := d.entries[name]
+	if !got {
+		return fmt.Errorf("unknown scraper '%s'", name)
+	}
+	if e.stopped {
+		return fmt.Errorf("scraper '%s' is stopped", name)
+	}
+	scraper := e.scraper
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		err := scraper.DoRunFromList(artURLs, d.db, updateMode)
+		if err != nil && err != ErrQuit {
+			fmt.Fprintf(os.Stderr, "ERROR (%s): list run failed: %s\n", name, err)
+		}
+	}()
+	return nil
+}
+
+// reload brings the running scrapers into line with a new set of configs.
+// New scrapers are started and removed ones stopped. Changed ones are
+// replaced once their current run (if any) is finished.
+// A config which fails to build is reported, and the old version (if
+// any) left running.
+func (d *daemon) reload(confs map[string]*ScraperConf, build func(name string, conf *ScraperConf) (Scraper, error)) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.stopping {
+		return
+	}
+
+	names := make([]string, 0, len(confs))
+	for name, _ := range confs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		conf := confs[name]
+		e, got := d.entries[name]
+		if got && reflect.DeepEqual(e.conf, conf) {
+			continue // unchanged
+		}
+		scraper, err := build(name, conf)
+		if err != nil {
+			if got {
+				fmt.Fprintf(os.Stderr, "ERROR: reload: %s (keeping old version of %s)\n", err, name)
+			} else {
+				fmt.Fprintf(os.Stderr, "ERROR: reload: %s\n", err)
+			}
+			continue
+		}
+		newEntry := &daemonEntry{scraper: scraper, conf: conf}
+		if !got {
+			fmt.Fprintf(os.Stderr, "reload: starting %s\n", name)
+			// if it was removed earlier, the old one might still be
+			// finishing
+			d.launch(newEntry, d.removed[name])
+			delete(d.removed, name)
+			continue
+		}
+		if e.stopped {
+			fmt.Fprintf(os.Stderr, "reload: %s changed, but was stopped (not restarting)\n", name)
+			e.conf = conf
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "reload: %s changed, replacing after current run\n", name)
+		pending := e.next != nil
+		e.conf = conf // so later reloads compare against the newest config
+		e.next = newEntry
+		if !pending {
+			d.wg.Add(1)
+			go d.replace(e)
+		}
+	}
+
+	for name, e := range d.entries {
+		if _, got := confs[name]; !got {
+			fmt.Fprintf(os.Stderr, "reload: stopping %s (removed)\n", name)
+			e.scraper.Stop()
+			e.next = nil
+			delete(d.entries, name)
+			d.removed[name] = e.done
+		}
+	}
+}
+
+// replace waits for an entry to finish its current run, then swaps in its
+// replacement (e.next).
+func (d *daemon) replace(e *daemonEntry) {
+	defer d.wg.Done()
+
+	var nextRun time.Time
+	paused := false
+	if c, ok := e.scraper.(Controllable); ok {
+		// remember if the operator paused it
+		paused = c.Paused()
+		// let any run in progress finish
+		c.Pause()
+		for {
+			state := c.State()
+			if state != "discovering" && state != "scraping" {
+				break
+			}
+			select {
+			case <-e.done:
+			case <-time.After(time.Second):
+			}
+		}
+		nextRun = c.NextRun()
+	}
+	e.scraper.Stop()
+	<-e.done
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.stopping || e.stopped || e.next == nil || d.entries[e.scraper.Name()] != e {
+		return // shutting down, or stopped/removed in the meantime
+	}
+	if c, ok := e.next.scraper.(Controllable); ok {
+		// carry on with the old schedule
+		if !nextRun.IsZero() {
+			c.SetNextRun(nextRun)
+		}
+		if paused {
+			c.Pause()
+		}
+	}
+	d.launch(e.next, nil)
+}
+
+// stopAll asks all the scrapers to stop.
+func (d *daemon) stopAll() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.stopping = true
+	for _, e := range d.entries {
+		e.scraper.Stop()
+	}
+}
+
+// wait blocks until all the scrapers have finished.
+func (d *daemon) wait() {
+	d.wg.Wait()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bcampbell/scrapeomat/store"
+)
+
+// daemonScraper is a fake Controllable scraper which records when it's
+// started, and takes a while to finish after Stop() (until release()).
+type daemonScraper struct {
+	*ctlScraper
+	started    chan struct{}
+	startOnce  sync.Once
+	finished   chan struct{}
+	finishOnce sync.Once
+}
+
+func newDaemonScraper(name string) *daemonScraper {
+	return &daemonScraper{
+		ctlScraper: newCtlScraper(name),
+		started:    make(chan struct{}),
+		finished:   make(chan struct{}),
+	}
+}
+
+func (s *daemonScraper) Start(db store.Store) {
+	s.startOnce.Do(func() { close(s.started) })
+	<-s.quit
+	<-s.finished
+}
+
+// release lets Start() return once Stop() has been called.
+func (s *daemonScraper) release() {
+	s.finishOnce.Do(func() { close(s.finished) })
+}
+
+func (s *daemonScraper) hasStarted() bool {
+	select {
+	case <-s.started:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitFor polls until cond is true, failing the test if it takes too long.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDaemonReload(t *testing.T) {
+	built := []*daemonScraper{}
+	build := func(name string, conf *ScraperConf) (Scraper, error) {
+		if conf.Type == "bad" {
+			return nil, fmt.Errorf("%s: bad config", name)
+		}
+		s := newDaemonScraper(name)
+		built = append(built, s)
+		return s, nil
+	}
+	confs := func(defs ...string) map[string]*ScraperConf {
+		out := map[string]*ScraperConf{}
+		for i := 0; i < len(defs); i += 2 {
+			conf := &ScraperConf{}
+			if defs[i+1] == "bad" {
+				conf.Type = "bad"
+			} else {
+				conf.URL = defs[i+1]
+			}
+			out[defs[i]] = conf
+		}
+		return out
+	}
+	d := newDaemon(nil)
+	defer func() {
+		for _, s := range built {
+			s.release()
+		}
+		d.stopAll()
+		d.wait()
+	}()
+	get := func(name string) *daemonScraper {
+		s, got := d.get(name)
+		if !got {
+			return nil
+		}
+		return s.(*daemonScraper)
+	}
+
+	// start up
+	d.reload(confs("a", "http://a.com/1", "b", "http://b.com/1"), build)
+	oldA, oldB := get("a"), get("b")
+	if len(built) != 2 || oldA == nil || oldB == nil {
+		t.Fatalf("expected a and b to be built, got %d scrapers", len(built))
+	}
+	waitFor(t, "a and b to start", func() bool { return oldA.hasStarted() && oldB.hasStarted() })
+
+	// nothing changed
+	d.reload(confs("a", "http://a.com/1", "b", "http://b.com/1"), build)
+	if len(built) != 2 || get("a") != oldA || get("b") != oldB {
+		t.Errorf("unchanged: expected no new scrapers, got %d", len(built))
+	}
+
+	// broken config keeps the old version running
+	d.reload(confs("a", "http://a.com/1", "b", "bad", "c", "bad"), build)
+	if get("b") != oldB || oldB.State() == "stopped" {
+		t.Errorf("bad config: expected old b to be kept running (state %s)", oldB.State())
+	}
+	if get("c") != nil {
+		t.Errorf("bad config: didn't expect c to be added")
+	}
+
+	// a changes. It's replaced once its current run is done, carrying
+	// on the old schedule (and pause).
+	nextRun := time.Now().Add(time.Hour).Truncate(time.Second)
+	oldA.SetNextRun(nextRun)
+	oldA.Pause()
+	oldA.setBusy(true)
+	oldA.release()
+	d.reload(confs("a", "http://a.com/2", "b", "http://b.com/1"), build)
+	time.Sleep(50 * time.Millisecond)
+	if get("a") != oldA || oldA.State() == "stopped" {
+		t.Errorf("replace: old a shouldn't be stopped during a run (state %s)", oldA.State())
+	}
+	oldA.setBusy(false)
+	waitFor(t, "a to be replaced", func() bool { return get("a") != oldA })
+	newA := get("a")
+	waitFor(t, "new a to start", newA.hasStarted)
+	if oldA.State() != "stopped" {
+		t.Errorf("replace: expected old a to be stopped, got %s", oldA.State())
+	}
+	if !newA.Paused() || !newA.NextRun().Equal(nextRun) {
+		t.Errorf("replace: expected paused with next run %s, got paused=%v, next run %s", nextRun, newA.Paused(), newA.NextRun())
+	}
+
+	// b is removed, then added back before the old one has finished. The
+	// new one mustn't start until the old one is done.
+	d.reload(confs("a", "http://a.com/2"), build)
+	if get("b") != nil || oldB.State() != "stopped" {
+		t.Errorf("remove: expected b to be stopped and removed")
+	}
+	d.reload(confs("a", "http://a.com/2", "b", "http://b.com/1"), build)
+	newB := get("b")
+	if newB == nil || newB == oldB {
+		t.Fatalf("re-add: expected a new b")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if newB.hasStarted() {
+		t.Errorf("re-add: new b started while old b still running")
+	}
+	oldB.release()
+	waitFor(t, "new b to start", newB.hasStarted)
+}
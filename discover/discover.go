@@ -11,16 +11,23 @@ package discover
 //   logging
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha1"
 	"errors"
 	"fmt"
 	"github.com/PuerkitoBio/purell"
 	"github.com/andybalholm/cascadia"
+	"github.com/bcampbell/scrapeomat/fetch"
 	"golang.org/x/net/html"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	//	"os"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
 type Logger interface {
@@ -67,13 +74,55 @@ type DiscovererDef struct {
 
 	// UserAgent string to use in HTTP requests
 	UserAgent string
+
+	// Sitemap lists sitemap files to look for articles in, as well as
+	// crawling the nav pages ("robots" means the ones listed in
+	// robots.txt)
+	Sitemap []string
+	// SitemapMaxAge ignores sitemap entries older than this (eg "48h")
+	SitemapMaxAge string
+	// SitemapOnly turns off nav page crawling, so only the sitemaps
+	// are used
+	SitemapOnly bool
+
+	// Feed lists RSS/Atom feeds to look for articles in, as well as
+	// crawling the nav pages
+	Feed []string
+
+	// limits on nav page crawling (0 = no limit).
+	// MaxDepth is the number of links to follow from the start page,
+	// MaxPages the number of nav pages to visit, and MaxDuration the
+	// time allowed for discovery (eg "10m").
+	MaxDepth    int
+	MaxPages    int
+	MaxDuration string
 }
 
 type DiscoverStats struct {
 	ErrorCount int
 	FetchCount int
+	// RetryCount is the number of retries made for transient HTTP failures,
+	// GiveUpCount the number of pages still failing after all retries.
+	RetryCount  int
+	GiveUpCount int
+	// DisallowedCount is the number of nav pages, feeds, sitemaps and
+	// sitemap articles skipped because of robots.txt
+	DisallowedCount int
+	// UnchangedCount is the number of nav pages which hadn't changed since
+	// the last run (and so weren't rescanned for links)
+	UnchangedCount int
+	// LimitHit is set if crawling stopped early because of a limit (one
+	// of the Limit* consts)
+	LimitHit string
 }
 
+// crawl limits, as reported in DiscoverStats.LimitHit
+const (
+	LimitDepth    = "maxdepth"
+	LimitPages    = "maxpages"
+	LimitDuration = "maxduration"
+)
+
 type Discoverer struct {
 	Name               string
 	StartURL           url.URL
@@ -87,14 +136,41 @@ type Discoverer struct {
 	StripQuery         bool
 	HostPat            *regexp.Regexp
 	UserAgent          string
+	// Sitemaps are sitemap files to get article links from (see
+	// DiscovererDef.Sitemap)
+	Sitemaps      []string
+	SitemapMaxAge time.Duration
+	SitemapOnly   bool
+	// Feeds are RSS/Atom feeds to get article links from
+	Feeds []string
+	// crawl limits (see DiscovererDef)
+	MaxDepth    int
+	MaxPages    int
+	MaxDuration time.Duration
+	// Hints holds any details about the articles found by the last Run
+	// (eg titles from feeds), keyed by article URL
+	Hints map[string]Hint
+	// Retry is the policy for retrying transient HTTP failures
+	Retry fetch.Policy
+	// Robots, if set, is used to skip pages disallowed by robots.txt
+	Robots *fetch.RobotsCache
+	// PageCache, if set, remembers nav pages between runs so that
+	// unchanged ones can be skipped. It's updated at the end of each
+	// successful run.
+	PageCache *PageCache
+	// identifies the rules used to find links (for invalidating PageCache)
+	rulesID string
+	// Report, if set, is filled in with the details of the next run (the
+	// PageCache isn't used, so every page is examined afresh).
+	Report *Report
 
 	ErrorLog Logger
 	InfoLog  Logger
 	Stats    DiscoverStats
 }
 
-// compile a slice of strings into a slice of regexps
-func buildRegExps(pats []string) ([]*regexp.Regexp, error) {
+// BuildRegExps compiles a slice of strings into a slice of regexps
+func BuildRegExps(pats []string) ([]*regexp.Regexp, error) {
 	out := make([]*regexp.Regexp, len(pats))
 	for idx, pat := range pats {
 		re, err := regexp.Compile(pat)
@@ -115,11 +191,11 @@ func NewDiscoverer(cfg DiscovererDef) (*Discoverer, error) {
 	disc.Name = cfg.Name
 	disc.StartURL = *u
 	// parse the regexp include/exclude rules
-	disc.ArtPats, err = buildRegExps(cfg.ArtPat)
+	disc.ArtPats, err = BuildRegExps(cfg.ArtPat)
 	if err != nil {
 		return nil, err
 	}
-	disc.XArtPats, err = buildRegExps(cfg.XArtPat)
+	disc.XArtPats, err = BuildRegExps(cfg.XArtPat)
 	if err != nil {
 		return nil, err
 	}
@@ -149,7 +225,7 @@ func NewDiscoverer(cfg DiscovererDef) (*Discoverer, error) {
 		disc.NavLinkSel = sel
 	}
 
-	disc.XNavPats, err = buildRegExps(cfg.XNavPat)
+	disc.XNavPats, err = BuildRegExps(cfg.XNavPat)
 	if err != nil {
 		return nil, err
 	}
@@ -180,6 +256,31 @@ func NewDiscoverer(cfg DiscovererDef) (*Discoverer, error) {
 
 	disc.UserAgent = cfg.UserAgent
 
+	disc.Sitemaps = cfg.Sitemap
+	if cfg.SitemapMaxAge != "" {
+		disc.SitemapMaxAge, err = time.ParseDuration(cfg.SitemapMaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("bad sitemapmaxage: %s", err)
+		}
+	}
+	disc.SitemapOnly = cfg.SitemapOnly
+	disc.Feeds = cfg.Feed
+
+	if cfg.MaxDepth < 0 || cfg.MaxPages < 0 {
+		return nil, fmt.Errorf("bad crawl limit: maxdepth and maxpages can't be negative")
+	}
+	disc.MaxDepth = cfg.MaxDepth
+	disc.MaxPages = cfg.MaxPages
+	if cfg.MaxDuration != "" {
+		disc.MaxDuration, err = time.ParseDuration(cfg.MaxDuration)
+		if err != nil {
+			return nil, fmt.Errorf("bad maxduration: %s", err)
+		}
+	}
+
+	disc.Retry = fetch.DefaultPolicy
+	disc.rulesID = fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("%#v", cfg))))
+
 	// defaults
 	disc.StripFragments = true
 	disc.StripQuery = !cfg.NoStripQuery
@@ -190,17 +291,78 @@ func NewDiscoverer(cfg DiscovererDef) (*Discoverer, error) {
 
 var ErrQuit = errors.New("quit requested")
 
+// navPage is a nav page waiting to be visited
+type navPage struct {
+	link  url.URL
+	depth int // number of links from the start page
+}
+
+// Run finds article links by crawling the nav pages (breadth-first, in a
+// consistent order), along with any sitemaps and feeds.
+// If a crawl limit is hit, the links found so far are returned, and
+// Stats.LimitHit says which limit it was.
 func (disc *Discoverer) Run(client *http.Client, quit <-chan struct{}) (LinkSet, error) {
 	// reset stats
 	disc.Stats = DiscoverStats{}
+	disc.Hints = map[string]Hint{}
+	started := time.Now()
 
-	queued := make(LinkSet) // nav pages to scan for article links
-	seen := make(LinkSet)   // nav pages we've scanned
+	queue := []navPage{}    // nav pages to scan for article links
+	queued := make(LinkSet) // nav pages we've queued (or scanned)
 	arts := make(LinkSet)   // article links we've found so far
 
-	queued.Add(disc.StartURL)
+	// previously-visited pages
+	var prevPages map[string]*PageState
+	if disc.PageCache != nil && disc.PageCache.Rules == disc.rulesID && disc.Report == nil {
+		prevPages = disc.PageCache.Pages
+	}
+
+	var report *Report
+	// nav page url -> page which first linked to it
+	referrers := map[string]string{}
+	if disc.Report != nil {
+		report = newReport(disc)
+		defer func() {
+			report.LimitHit = disc.Stats.LimitHit
+			report.finish()
+			*disc.Report = *report
+		}()
+	}
 
-	for len(queued) > 0 {
+	// for abandoning requests in progress if quit is closed
+	ctx, cancel := fetch.QuitContext(quit)
+	defer cancel()
+
+	if len(disc.Sitemaps) > 0 {
+		found, err := disc.runSitemaps(client, quit, report)
+		if err == fetch.ErrQuit {
+			return nil, ErrQuit
+		}
+		if err != nil {
+			return nil, err
+		}
+		arts.Merge(found)
+	}
+	if len(disc.Feeds) > 0 {
+		found, err := disc.runFeeds(ctx, client, quit, report)
+		if err == fetch.ErrQuit {
+			return nil, ErrQuit
+		}
+		if err != nil {
+			return nil, err
+		}
+		arts.Merge(found)
+	}
+	if !disc.SitemapOnly {
+		queue = append(queue, navPage{link: disc.StartURL})
+		queued.Add(disc.StartURL)
+	}
+	pages := map[string]*PageState{}
+	failed := map[string]bool{} // pages we couldn't fetch this time
+	visited := 0
+	depthLimited := false
+
+	for len(queue) > 0 {
 
 		if quit != nil {
 			select {
@@ -209,14 +371,54 @@ func (disc *Discoverer) Run(client *http.Client, quit <-chan struct{}) (LinkSet,
 			default:
 			}
 		}
-		pageURL := queued.Pop()
-		seen.Add(pageURL)
-		//
+		if disc.MaxPages > 0 && visited >= disc.MaxPages {
+			disc.Stats.LimitHit = LimitPages
+			break
+		}
+		if disc.MaxDuration > 0 && time.Since(started) >= disc.MaxDuration {
+			disc.Stats.LimitHit = LimitDuration
+			break
+		}
+		page := queue[0]
+		queue = queue[1:]
+		visited++
+		pageURL := page.link
+
+		var pageReport *PageReport
+		if report != nil {
+			pageReport = &PageReport{
+				URL:      pageURL.String(),
+				Depth:    page.depth,
+				Referrer: referrers[pageURL.String()],
+				NavLinks: []string{},
+				Dropped:  []DroppedLink{},
+				ArtLinks: []string{},
+			}
+			report.Pages = append(report.Pages, pageReport)
+		}
 
-		root, err := disc.fetchAndParse(client, &pageURL)
+		if disc.Robots != nil && !disc.Robots.Allowed(&pageURL) {
+			disc.InfoLog.Printf("Skipping %s (disallowed by robots.txt)\n", pageURL.String())
+			disc.Stats.DisallowedCount++
+			if pageReport != nil {
+				pageReport.Status = "disallowed"
+			}
+			continue
+		}
+
+		prev := prevPages[pageURL.String()]
+		root, state, err := disc.fetchAndParse(ctx, client, &pageURL, prev, quit)
+		if err == fetch.ErrQuit {
+			return nil, ErrQuit
+		}
 		if err != nil {
+			if pageReport != nil {
+				pageReport.Status = "error"
+				pageReport.Error = err.Error()
+			}
 			disc.ErrorLog.Printf("%s\n", err.Error())
 			disc.Stats.ErrorCount++
+			failed[pageURL.String()] = true
 			if disc.Stats.ErrorCount > disc.BaseErrorThreshold+(disc.Stats.FetchCount/10) {
 				return nil, errors.New("Error threshold exceeded")
 			} else {
@@ -243,90 +445,190 @@ func (disc *Discoverer) Run(client *http.Client, quit <-chan struct{}) (LinkSet,
 		*/
 		// end debugging hack
 
-		// remove cruft from page before discovery
-		if disc.CruftSel != nil {
-			for _, cruft := range disc.CruftSel.MatchAll(root) {
-				if cruft.Parent != nil { // check to handle nested cruft...
-					cruft.Parent.RemoveChild(cruft)
+		var navLinks, foo LinkSet
+		if root == nil {
+			// page unchanged since last run - reuse the links we found then
+			disc.Stats.UnchangedCount++
+			navLinks = linkSetFromStrings(prev.NavLinks)
+			foo = linkSetFromStrings(prev.ArtLinks)
+			state = prev
+		} else {
+			// remove cruft from page before discovery
+			if disc.CruftSel != nil {
+				for _, cruft := range disc.CruftSel.MatchAll(root) {
+					if cruft.Parent != nil { // check to handle nested cruft...
+						cruft.Parent.RemoveChild(cruft)
+					}
 				}
 			}
-		}
 
-		navLinks, err := disc.findNavLinks(&pageURL, root)
-		if err != nil {
-			return nil, err
-		}
-		for navLink, _ := range navLinks {
-			if _, got := seen[navLink]; !got {
-				queued.Add(navLink)
+			var dropped []DroppedLink
+			navLinks, dropped, err = disc.findNavLinks(&pageURL, root)
+			if err != nil {
+				return nil, err
 			}
+			var nonArts []*url.URL
+			foo, nonArts, err = disc.findArticles(&pageURL, root)
+			if err != nil {
+				return nil, err
+			}
+			state.NavLinks = navLinks.Strings()
+			state.ArtLinks = foo.Strings()
+
+			if pageReport != nil {
+				pageReport.Dropped = append(pageReport.Dropped, dropped...)
+				for _, u := range nonArts {
+					report.addNonArticle(u)
+				}
+			}
+		}
+		pages[pageURL.String()] = state
+
+		if pageReport != nil {
+			pageReport.Status = "ok"
+			pageReport.NavLinks = navLinks.Strings()
+			pageReport.ArtLinks = foo.Strings()
+			sort.Strings(pageReport.NavLinks)
+			sort.Strings(pageReport.ArtLinks)
 		}
 
-		foo, err := disc.findArticles(&pageURL, root)
-		if err != nil {
-			return nil, err
+		// queue up new nav links (sorted, so runs are repeatable)
+		for _, navLink := range navLinks.Sorted() {
+			if _, got := queued[navLink]; got {
+				continue
+			}
+			if disc.MaxDepth > 0 && page.depth >= disc.MaxDepth {
+				depthLimited = true
+				continue
+			}
+			queue = append(queue, navPage{link: navLink, depth: page.depth + 1})
+			queued.Add(navLink)
+			referrers[navLink.String()] = pageURL.String()
 		}
 		arts.Merge(foo)
 
-		disc.InfoLog.Printf("Visited %s, found %d articles\n", pageURL.String(), len(foo))
+		if root == nil {
+			disc.InfoLog.Printf("Visited %s (unchanged), found %d articles\n", pageURL.String(), len(foo))
+		} else {
+			disc.InfoLog.Printf("Visited %s, found %d articles\n", pageURL.String(), len(foo))
+		}
 	}
 
+	if disc.Stats.LimitHit == "" && depthLimited {
+		disc.Stats.LimitHit = LimitDepth
+	}
+	if disc.Stats.LimitHit != "" {
+		disc.InfoLog.Printf("Stopped crawling early (%s limit reached, %d pages still queued)\n", disc.Stats.LimitHit, len(queue))
+	}
+
+	if disc.PageCache != nil {
+		if disc.PageCache.Rules == disc.rulesID {
+			// Only forget pages we know aren't reachable any more. If we
+			// stopped early or couldn't fetch a page, we don't know that.
+			for u, state := range disc.PageCache.Pages {
+				if _, got := pages[u]; !got && (disc.Stats.LimitHit != "" || failed[u]) {
+					pages[u] = state
+				}
+			}
+		}
+		disc.PageCache.Rules = disc.rulesID
+		disc.PageCache.Pages = pages
+	}
 	return arts, nil
 }
 
-func (disc *Discoverer) fetchAndParse(c *http.Client, pageURL *url.URL) (*html.Node, error) {
-	req, err := http.NewRequest("GET", pageURL.String(), nil)
+// fetchAndParse fetches and parses a nav page, returning the new state of
+// the page (minus the links, which are up to the caller).
+// If prev is set, it's used to make a conditional request, and if the page
+// hasn't changed a nil root is returned.
+func (disc *Discoverer) fetchAndParse(ctx context.Context, c *http.Client, pageURL *url.URL, prev *PageState, quit <-chan struct{}) (*html.Node, *PageState, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL.String(), nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if prev != nil {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
 	}
-	// NOTE: FT.com always returns 403 if no Accept header is present.
-	// Seems like a reasonable thing to send anyway...
-	// req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
-	req.Header.Set("Accept", "*/*")
-	if disc.UserAgent != "" {
-		req.Header.Set("User-Agent", disc.UserAgent)
+	resp, res, err := disc.Retry.Do(c, req, quit)
+	disc.Stats.RetryCount += res.Retries
+	if res.GaveUp {
+		disc.Stats.GiveUpCount++
 	}
-
-	resp, err := c.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && prev != nil {
+		return nil, prev, nil
+	}
+
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		err = errors.New(fmt.Sprintf("HTTP code %d (%s)", resp.StatusCode, pageURL.String()))
 
-		return nil, err
+		return nil, nil, err
 
 	}
 
-	root, err := html.Parse(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	state := &PageState{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Hash:         fmt.Sprintf("%x", sha1.Sum(body)),
+		Fetched:      time.Now(),
+	}
+	if prev != nil && prev.Hash == state.Hash {
+		// same content (server just doesn't do conditional requests)
+		prev.ETag = state.ETag
+		prev.LastModified = state.LastModified
+		prev.Fetched = state.Fetched
+		return nil, prev, nil
+	}
+
+	root, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return root, nil
+	return root, state, nil
 }
 
 var aSel cascadia.Selector = cascadia.MustCompile("a")
 
-func (disc *Discoverer) findArticles(baseURL *url.URL, root *html.Node) (LinkSet, error) {
+// findArticles returns the article links on a page, along with any
+// non-article links on accepted hosts.
+func (disc *Discoverer) findArticles(baseURL *url.URL, root *html.Node) (LinkSet, []*url.URL, error) {
 	arts := make(LinkSet)
+	nonArts := []*url.URL{}
 	for _, a := range aSel.MatchAll(root) {
 
 		rawURL := GetAttr(a, "href")
 		u, err := disc.CookArticleURL(baseURL, rawURL)
 		if err != nil {
+			if u, err := disc.NormaliseURL(baseURL, rawURL); err == nil && disc.IsHostGood(u.Host) {
+				nonArts = append(nonArts, u)
+			}
 			continue
 		}
 		arts[*u] = true
 	}
-	return arts, nil
+	return arts, nonArts, nil
 }
 
-func (disc *Discoverer) CookArticleURL(baseURL *url.URL, artLink string) (*url.URL, error) {
+// NormaliseURL resolves a link against baseURL and tidies it up using
+// the site rules (fragment and query stripping etc), without checking
+// whether it's an article.
+func (disc *Discoverer) NormaliseURL(baseURL *url.URL, link string) (*url.URL, error) {
 	// parse, extending to absolute
-	u, err := baseURL.Parse(artLink)
+	u, err := baseURL.Parse(link)
 	if err != nil {
 		return nil, err
 	}
@@ -341,13 +643,17 @@ func (disc *Discoverer) CookArticleURL(baseURL *url.URL, artLink string) (*url.U
 	// normalise url (strip trailing /, etc)
 	normalised := purell.NormalizeURL(u, purell.FlagsUsuallySafeGreedy)
 	// need it back as a url.URL
-	u, err = url.Parse(normalised)
+	return url.Parse(normalised)
+}
+
+func (disc *Discoverer) CookArticleURL(baseURL *url.URL, artLink string) (*url.URL, error) {
+	u, err := disc.NormaliseURL(baseURL, artLink)
 	if err != nil {
 		return nil, err
 	}
 
 	// on a host we accept?
-	if !disc.isHostGood(u.Host) {
+	if !disc.IsHostGood(u.Host) {
 		return nil, fmt.Errorf("bad host (%s)", u.Host)
 	}
 
@@ -374,10 +680,13 @@ func (disc *Discoverer) CookArticleURL(baseURL *url.URL, artLink string) (*url.U
 	return u, nil
 }
 
-func (disc *Discoverer) findNavLinks(pageURL *url.URL, root *html.Node) (LinkSet, error) {
+// findNavLinks returns the nav links on a page, along with any links
+// matched by NavLinkSel which were excluded.
+func (disc *Discoverer) findNavLinks(pageURL *url.URL, root *html.Node) (LinkSet, []DroppedLink, error) {
 	navLinks := make(LinkSet)
+	dropped := []DroppedLink{}
 	if disc.NavLinkSel == nil {
-		return navLinks, nil
+		return navLinks, dropped, nil
 	}
 	for _, a := range disc.NavLinkSel.MatchAll(root) {
 		link, err := pageURL.Parse(GetAttr(a, "href"))
@@ -385,7 +694,8 @@ func (disc *Discoverer) findNavLinks(pageURL *url.URL, root *html.Node) (LinkSet
 			continue
 		}
 
-		if !disc.isHostGood(link.Host) {
+		if !disc.IsHostGood(link.Host) {
+			dropped = append(dropped, DroppedLink{link.String(), "bad host"})
 			continue
 		}
 
@@ -393,6 +703,7 @@ func (disc *Discoverer) findNavLinks(pageURL *url.URL, root *html.Node) (LinkSet
 		skip := false
 		for _, pat := range disc.XNavPats {
 			if pat.MatchString(link.RequestURI()) {
+				dropped = append(dropped, DroppedLink{link.String(), "xnavpat " + pat.String()})
 				skip = true
 				break
 			}
@@ -405,11 +716,11 @@ func (disc *Discoverer) findNavLinks(pageURL *url.URL, root *html.Node) (LinkSet
 
 		navLinks[*link] = true
 	}
-	return navLinks, nil
+	return navLinks, dropped, nil
 }
 
-// is host domain one we'll accept?
-func (disc *Discoverer) isHostGood(host string) bool {
+// IsHostGood returns true if host is a domain we'll accept (see HostPat).
+func (disc *Discoverer) IsHostGood(host string) bool {
 	if disc.HostPat != nil {
 		return disc.HostPat.MatchString(host)
 	}
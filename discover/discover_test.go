@@ -0,0 +1,129 @@
+package discover
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bcampbell/scrapeomat/fetch"
+)
+
+func TestCrawlLimits(t *testing.T) {
+	// nav links, listed out of order (and with a duplicate) to check the
+	// crawl is breadth-first and sorted
+	navLinks := map[string][]string{
+		"/":  {"/b", "/a"},
+		"/a": {"/a/deep"},
+		"/b": {"/b/deep", "/a"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><body>`)
+		for _, l := range navLinks[r.URL.Path] {
+			fmt.Fprintf(w, `<a class="nav" href="%s">nav</a>`, l)
+		}
+		fmt.Fprintf(w, `<a href="/art%s/1">art</a></body></html>`, strings.TrimSuffix(r.URL.Path, "/"))
+	}))
+	defer srv.Close()
+
+	testData := []struct {
+		def         DiscovererDef
+		expectPages string
+		expectLimit string
+	}{
+		{DiscovererDef{}, "/,/a,/b,/a/deep,/b/deep", ""},
+		{DiscovererDef{MaxDepth: 1}, "/,/a,/b", LimitDepth},
+		{DiscovererDef{MaxPages: 2}, "/,/a", LimitPages},
+		{DiscovererDef{MaxDepth: 2, MaxPages: 5}, "/,/a,/b,/a/deep,/b/deep", ""},
+	}
+
+	for _, dat := range testData {
+		def := dat.def
+		def.URL = srv.URL + "/"
+		def.ArtPat = []string{`/art/`}
+		def.NavSel = "a.nav"
+		disc, err := NewDiscoverer(def)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := &Report{}
+		disc.Report = report
+		arts, err := disc.Run(&http.Client{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		visited := []string{}
+		for _, page := range report.Pages {
+			visited = append(visited, strings.TrimPrefix(page.URL, srv.URL))
+		}
+		got := strings.Join(visited, ",")
+		if got != dat.expectPages {
+			t.Errorf("%+v: expected pages %s, got %s", dat.def, dat.expectPages, got)
+		}
+		if len(arts) != len(visited) {
+			t.Errorf("%+v: expected %d articles, got %d", dat.def, len(visited), len(arts))
+		}
+		if disc.Stats.LimitHit != dat.expectLimit || report.LimitHit != dat.expectLimit {
+			t.Errorf("%+v: expected limit %q, got %q (report %q)", dat.def, dat.expectLimit, disc.Stats.LimitHit, report.LimitHit)
+		}
+	}
+
+	if _, err := NewDiscoverer(DiscovererDef{URL: srv.URL, MaxDuration: "soon"}); err == nil {
+		t.Errorf("expected error for bad maxduration")
+	}
+}
+
+func TestSitemapRobots(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprintf(w, "User-agent: *\nDisallow: /private/\n")
+		case "/sitemap.xml":
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>%s/art/1</loc></url>
+<url><loc>%s/private/art/2</loc></url>
+<url><loc>%s/art/3</loc></url>
+</urlset>`, srv.URL, srv.URL, srv.URL)
+		case "/index.xml":
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<sitemap><loc>%s/sitemap.xml</loc></sitemap>
+<sitemap><loc>%s/private/sitemap.xml</loc></sitemap>
+</sitemapindex>`, srv.URL, srv.URL)
+		default:
+			t.Errorf("unexpected fetch: %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	disc, err := NewDiscoverer(DiscovererDef{
+		URL:         srv.URL + "/",
+		ArtPat:      []string{`/art/\d+`},
+		Sitemap:     []string{"/index.xml"},
+		SitemapOnly: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	disc.Robots = fetch.NewRobotsCache(srv.Client(), "")
+	arts, err := disc.Run(srv.Client(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, u := range arts.Sorted() {
+		got = append(got, strings.TrimPrefix(u.String(), srv.URL))
+	}
+	if strings.Join(got, ",") != "/art/1,/art/3" {
+		t.Errorf("expected /art/1,/art/3, got %s", strings.Join(got, ","))
+	}
+	// the private sitemap and the private article
+	if disc.Stats.DisallowedCount != 2 {
+		t.Errorf("expected 2 disallowed, got %d", disc.Stats.DisallowedCount)
+	}
+}
@@ -0,0 +1,99 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bcampbell/scrapeomat/feed"
+	"github.com/bcampbell/scrapeomat/fetch"
+)
+
+// Hint holds details about an article picked up during discovery (eg from
+// a feed), which can be used to fill in anything the extractor misses.
+type Hint struct {
+	Title string
+	// Published is an ISO8601 timestamp ("" if unknown). It has no
+	// timezone if the source didn't give one.
+	Published string
+}
+
+// runFeeds looks for article links in the RSS/Atom feeds, recording any
+// titles and dates in disc.Hints.
+// Non-article links are recorded in report (if not nil).
+func (disc *Discoverer) runFeeds(ctx context.Context, client *http.Client, quit <-chan struct{}, report *Report) (LinkSet, error) {
+	arts := make(LinkSet)
+	for _, loc := range disc.Feeds {
+		// allow feeds relative to the start url
+		feedURL, err := disc.StartURL.Parse(loc)
+		if err != nil {
+			disc.ErrorLog.Printf("bad feed url %s: %s\n", loc, err)
+			disc.Stats.ErrorCount++
+			continue
+		}
+		if disc.Robots != nil && !disc.Robots.Allowed(feedURL) {
+			disc.InfoLog.Printf("Skipping %s (disallowed by robots.txt)\n", feedURL)
+			disc.Stats.DisallowedCount++
+			continue
+		}
+
+		items, err := disc.fetchFeed(ctx, client, feedURL.String(), quit)
+		if err == fetch.ErrQuit {
+			return nil, err
+		}
+		if err != nil {
+			disc.ErrorLog.Printf("feed %s: %s\n", feedURL, err)
+			disc.Stats.ErrorCount++
+			continue
+		}
+		disc.Stats.FetchCount++
+
+		found := 0
+		for _, item := range items {
+			cooked, err := disc.CookArticleURL(feedURL, item.Link)
+			if err != nil {
+				if report != nil {
+					if u, err := disc.NormaliseURL(feedURL, item.Link); err == nil && disc.IsHostGood(u.Host) {
+						report.addNonArticle(u)
+					}
+				}
+				continue
+			}
+			arts[*cooked] = true
+			found++
+			hint := Hint{Title: item.Title}
+			if !item.Published.IsZero() {
+				if item.Naive {
+					// leave it for the scraper to apply its timezone
+					hint.Published = item.Published.Format("2006-01-02T15:04:05")
+				} else {
+					hint.Published = item.Published.Format(time.RFC3339)
+				}
+			}
+			disc.Hints[cooked.String()] = hint
+		}
+		disc.InfoLog.Printf("Feed %s: %d items, found %d articles\n", feedURL, len(items), found)
+	}
+	return arts, nil
+}
+
+func (disc *Discoverer) fetchFeed(ctx context.Context, client *http.Client, feedURL string, quit <-chan struct{}) ([]feed.Item, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, res, err := disc.Retry.Do(client, req, quit)
+	disc.Stats.RetryCount += res.Retries
+	if res.GaveUp {
+		disc.Stats.GiveUpCount++
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP code %d", resp.StatusCode)
+	}
+	return feed.Parse(resp.Body)
+}
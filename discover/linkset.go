@@ -1,21 +1,24 @@
 package discover
 
 import (
-	"errors"
 	"net/url"
+	"sort"
 )
 
 // thin map wrapper for some set operations
 type LinkSet map[url.URL]bool
 
-// remove and return a single item from the set
-func (s *LinkSet) Pop() url.URL {
-	for u, _ := range *s {
-		delete(*s, u)
-		return u
+// return the links as a slice, sorted by their string form (so iteration
+// order is repeatable)
+func (s LinkSet) Sorted() []url.URL {
+	out := make([]url.URL, 0, len(s))
+	for link, _ := range s {
+		out = append(out, link)
 	}
-	// panic!
-	panic(errors.New("tried to Pop() on empty LinkSet"))
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].String() < out[j].String()
+	})
+	return out
 }
 
 func (s *LinkSet) Add(link url.URL) {
@@ -0,0 +1,106 @@
+package discover
+
+// Remembering nav pages between runs, so unchanged pages can be skipped.
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// PageState holds what we know about a nav page from a previous run.
+type PageState struct {
+	// ETag and LastModified are the validators sent by the server (if any),
+	// for use in conditional requests.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Hash is a hash of the page body, for servers which don't support
+	// conditional requests.
+	Hash string `json:"hash"`
+	// NavLinks and ArtLinks are the links found on the page
+	NavLinks []string  `json:"nav_links"`
+	ArtLinks []string  `json:"art_links"`
+	Fetched  time.Time `json:"fetched"`
+}
+
+// PageCache holds the state of the nav pages visited during previous
+// discovery runs. Pages are only dropped once a complete crawl shows
+// they're no longer linked to.
+type PageCache struct {
+	// Rules identifies the discovery rules in effect when the pages were
+	// visited. If the rules change, the cached links are invalid.
+	Rules string                `json:"rules"`
+	Pages map[string]*PageState `json:"pages"`
+}
+
+// LoadPageCache reads a PageCache from a file. A missing file just gives an
+// empty cache.
+func LoadPageCache(filename string) (*PageCache, error) {
+	cache := &PageCache{Pages: map[string]*PageState{}}
+	raw, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(raw, cache)
+	if err != nil {
+		return nil, err
+	}
+	if cache.Pages == nil {
+		cache.Pages = map[string]*PageState{}
+	}
+	return cache, nil
+}
+
+// Save writes the cache out to a file (via a temp file, so a crash won't
+// leave a half-written cache).
+func (cache *PageCache) Save(filename string) error {
+	raw, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(raw)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Strings returns the links in the set as a sorted slice of strings.
+func (s LinkSet) Strings() []string {
+	out := make([]string, 0, len(s))
+	for link, _ := range s {
+		out = append(out, link.String())
+	}
+	sort.Strings(out)
+	return out
+}
+
+// linkSetFromStrings builds a LinkSet, skipping any unparsable urls
+func linkSetFromStrings(links []string) LinkSet {
+	s := make(LinkSet)
+	for _, l := range links {
+		u, err := url.Parse(l)
+		if err != nil {
+			continue
+		}
+		s.Add(*u)
+	}
+	return s
+}
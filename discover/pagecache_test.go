@@ -0,0 +1,127 @@
+package discover
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUnchangedPages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			// supports conditional requests
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			fmt.Fprintf(w, `<html><body><a class="nav" href="/news">news</a><a href="/art/1">one</a></body></html>`)
+		case "/news":
+			// doesn't support conditional requests
+			fmt.Fprintf(w, `<html><body><a href="/art/2">two</a></body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	disc, err := NewDiscoverer(DiscovererDef{
+		URL:    srv.URL + "/",
+		ArtPat: []string{`/art/\d+`},
+		NavSel: "a.nav",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	disc.PageCache = &PageCache{}
+
+	for run, expectUnchanged := range []int{0, 2} {
+		arts, err := disc.Run(&http.Client{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(arts) != 2 {
+			t.Errorf("run %d: expected 2 articles, got %d", run, len(arts))
+		}
+		if disc.Stats.UnchangedCount != expectUnchanged {
+			t.Errorf("run %d: expected %d unchanged pages, got %d", run, expectUnchanged, disc.Stats.UnchangedCount)
+		}
+	}
+}
+
+func TestPageCacheLimits(t *testing.T) {
+	navLinks := map[string][]string{
+		"/":  {"/a", "/b"},
+		"/a": {"/a/deep"},
+		"/b": {"/b/deep"},
+	}
+	broken := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == broken {
+			http.Error(w, "oops", http.StatusInternalServerError)
+			return
+		}
+		etag := fmt.Sprintf(`"%d"`, len(navLinks[r.URL.Path]))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprintf(w, `<html><body>`)
+		for _, l := range navLinks[r.URL.Path] {
+			fmt.Fprintf(w, `<a class="nav" href="%s">nav</a>`, l)
+		}
+		fmt.Fprintf(w, `</body></html>`)
+	}))
+	defer srv.Close()
+
+	disc, err := NewDiscoverer(DiscovererDef{
+		URL:    srv.URL + "/",
+		ArtPat: []string{`/art/\d+`},
+		NavSel: "a.nav",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	disc.PageCache = &PageCache{}
+
+	testData := []struct {
+		maxPages        int
+		broken          string
+		expectUnchanged int
+		expectCached    int
+	}{
+		{0, "", 0, 5},
+		// stopping early shouldn't lose the pages we didn't get to
+		{2, "", 2, 5},
+		{0, "", 5, 5},
+		// nor should a page which failed
+		{0, "/b/deep", 4, 5},
+		{0, "", 5, 5},
+	}
+	for run, dat := range testData {
+		disc.MaxPages = dat.maxPages
+		broken = dat.broken
+		_, err := disc.Run(&http.Client{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if disc.Stats.UnchangedCount != dat.expectUnchanged {
+			t.Errorf("run %d: expected %d unchanged pages, got %d", run, dat.expectUnchanged, disc.Stats.UnchangedCount)
+		}
+		if len(disc.PageCache.Pages) != dat.expectCached {
+			t.Errorf("run %d: expected %d cached pages, got %d", run, dat.expectCached, len(disc.PageCache.Pages))
+		}
+	}
+
+	// a complete crawl drops pages which are no longer linked to
+	navLinks["/b"] = nil
+	if _, err := disc.Run(&http.Client{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, got := disc.PageCache.Pages[srv.URL+"/b/deep"]; got || len(disc.PageCache.Pages) != 4 {
+		t.Errorf("expected /b/deep to be dropped from the cache, got %d pages", len(disc.PageCache.Pages))
+	}
+}
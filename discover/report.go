@@ -0,0 +1,222 @@
+package discover
+
+// Discovery reports, for debugging scraper configs - which nav pages were
+// visited, which links were followed or dropped, and what the non-article
+// links look like.
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// max number of example links kept for each group of non-article links
+const maxSamples = 5
+
+// Report holds the details of a discovery run.
+// Set Discoverer.Report to have Run() fill one in.
+type Report struct {
+	Name     string        `json:"name"`
+	StartURL string        `json:"start_url"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Pages    []*PageReport `json:"pages"`
+	// LimitHit is set if the crawl stopped early because of a limit
+	// ("maxdepth", "maxpages" or "maxduration")
+	LimitHit string `json:"limit_hit,omitempty"`
+	// NonArticles groups the links which weren't accepted as articles
+	// (but were on an accepted host) by the shape of their paths, most
+	// common first.
+	NonArticles []*LinkGroup `json:"non_articles"`
+
+	groups  map[string]*LinkGroup
+	nonArts map[string]bool
+}
+
+// PageReport describes a single nav page.
+type PageReport struct {
+	URL string `json:"url"`
+	// Referrer is the page which first linked to this one (empty for the
+	// start page)
+	Referrer string `json:"referrer,omitempty"`
+	// Depth is the number of links followed from the start page
+	Depth int `json:"depth"`
+	// Status is one of "ok", "error" or "disallowed"
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// NavLinks are the links matched by navsel which were followed
+	NavLinks []string `json:"nav_links"`
+	// Dropped are the links matched by navsel which weren't followed
+	Dropped []DroppedLink `json:"dropped"`
+	// ArtLinks are the article links found on the page
+	ArtLinks []string `json:"art_links"`
+}
+
+// DroppedLink is a nav link which was excluded, and why.
+type DroppedLink struct {
+	URL    string `json:"url"`
+	Reason string `json:"reason"`
+}
+
+// LinkGroup is a set of links with the same path shape (eg
+// "/news/YYYY/MM/SLUG.html").
+type LinkGroup struct {
+	Shape   string   `json:"shape"`
+	Count   int      `json:"count"`
+	Samples []string `json:"samples"`
+}
+
+func newReport(disc *Discoverer) *Report {
+	return &Report{
+		Name:     disc.Name,
+		StartURL: disc.StartURL.String(),
+		Started:  time.Now(),
+		Pages:    []*PageReport{},
+		groups:   map[string]*LinkGroup{},
+		nonArts:  map[string]bool{},
+	}
+}
+
+// addNonArticle records a link which wasn't accepted as an article
+func (r *Report) addNonArticle(u *url.URL) {
+	link := u.String()
+	if r.nonArts[link] {
+		return
+	}
+	r.nonArts[link] = true
+	shape := PathShape(u.Path)
+	g, got := r.groups[shape]
+	if !got {
+		g = &LinkGroup{Shape: shape, Samples: []string{}}
+		r.groups[shape] = g
+	}
+	g.Count++
+	if len(g.Samples) < maxSamples {
+		g.Samples = append(g.Samples, link)
+	}
+}
+
+// finish sorts out the groups of non-article links
+func (r *Report) finish() {
+	r.Finished = time.Now()
+	r.NonArticles = make([]*LinkGroup, 0, len(r.groups))
+	for _, g := range r.groups {
+		r.NonArticles = append(r.NonArticles, g)
+	}
+	sort.Slice(r.NonArticles, func(i, j int) bool {
+		a, b := r.NonArticles[i], r.NonArticles[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Shape < b.Shape
+	})
+}
+
+var (
+	yearPat   = regexp.MustCompile(`^(19|20)\d\d$`)
+	idPat     = regexp.MustCompile(`^\d{4,}$`)
+	numPat    = regexp.MustCompile(`^\d{1,3}$`)
+	idSlugPat = regexp.MustCompile(`^\d{4,}-[^-]+-`)
+	extPat    = regexp.MustCompile(`\.[a-zA-Z0-9]{1,5}$`)
+	slugPat   = regexp.MustCompile(`^[^-]+-[^-]`)
+)
+
+// PathShape sums up the form of a URL path, using the same placeholders
+// as artform (ID, SLUG, YYYY, MM, DD), eg:
+//
+//	/news/2017/01/05/moon-made-of-cheese.html => /news/YYYY/MM/DD/SLUG.html
+//
+// Other numbers become N.
+func PathShape(path string) string {
+	segs := strings.Split(path, "/")
+	prev := ""
+	for i, seg := range segs {
+		ext := extPat.FindString(seg)
+		base := strings.TrimSuffix(seg, ext)
+		shape := base
+		switch {
+		case yearPat.MatchString(base):
+			shape = "YYYY"
+		case idPat.MatchString(base):
+			shape = "ID"
+		case numPat.MatchString(base) && prev == "YYYY":
+			shape = "MM"
+		case numPat.MatchString(base) && prev == "MM":
+			shape = "DD"
+		case numPat.MatchString(base):
+			shape = "N"
+		case idSlugPat.MatchString(base):
+			shape = "ID-SLUG"
+		case slugPat.MatchString(base):
+			shape = "SLUG"
+		}
+		segs[i] = shape + ext
+		prev = shape
+	}
+	return strings.Join(segs, "/")
+}
+
+// WriteReportsJSON writes out reports as JSON.
+func WriteReportsJSON(w io.Writer, reports []*Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(reports)
+}
+
+// WriteReportsHTML writes out reports as a standalone HTML page.
+func WriteReportsHTML(w io.Writer, reports []*Report) error {
+	return reportTmpl.Execute(w, reports)
+}
+
+var reportTmpl = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Discovery report</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+.page { border-top: 1px solid #ccc; margin-top: 1em; }
+.error, .disallowed { color: #a00; }
+.dropped { color: #888; }
+details { margin-left: 1em; }
+</style>
+</head>
+<body>
+{{range .}}
+<h1>{{.Name}}</h1>
+<p>Started at <a href="{{.StartURL}}">{{.StartURL}}</a>, {{len .Pages}} nav pages ({{.Started.Format "2006-01-02 15:04:05"}} to {{.Finished.Format "15:04:05"}})</p>
+{{if .LimitHit}}<p class="error">Crawl stopped early: {{.LimitHit}} limit reached</p>{{end}}
+
+<h2>Non-article links</h2>
+<table>
+<tr><th>shape</th><th>count</th><th>examples</th></tr>
+{{range .NonArticles}}
+<tr><td><code>{{.Shape}}</code></td><td>{{.Count}}</td><td>{{range .Samples}}<a href="{{.}}">{{.}}</a><br>{{end}}</td></tr>
+{{end}}
+</table>
+
+<h2>Nav pages</h2>
+{{range .Pages}}
+<div class="page">
+<h3 class="{{.Status}}"><a href="{{.URL}}">{{.URL}}</a> ({{.Status}}{{if .Error}}: {{.Error}}{{end}}, depth {{.Depth}})</h3>
+{{if .Referrer}}<p>linked from <a href="{{.Referrer}}">{{.Referrer}}</a></p>{{end}}
+<details><summary>{{len .NavLinks}} nav links followed</summary>
+{{range .NavLinks}}<a href="{{.}}">{{.}}</a><br>{{end}}
+</details>
+<details><summary>{{len .Dropped}} nav links dropped</summary>
+{{range .Dropped}}<span class="dropped"><a href="{{.URL}}">{{.URL}}</a> ({{.Reason}})</span><br>{{end}}
+</details>
+<details><summary>{{len .ArtLinks}} article links</summary>
+{{range .ArtLinks}}<a href="{{.}}">{{.}}</a><br>{{end}}
+</details>
+</div>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,26 @@
+package discover
+
+import (
+	"testing"
+)
+
+func TestPathShape(t *testing.T) {
+	data := []struct {
+		path   string
+		expect string
+	}{
+		{"/news/2017/01/05/moon-made-of-cheese.html", "/news/YYYY/MM/DD/SLUG.html"},
+		{"/video/12345-funny-cat", "/video/ID-SLUG"},
+		{"/story/123456", "/story/ID"},
+		{"/gallery/page/2/", "/gallery/page/N/"},
+		{"/about", "/about"},
+		{"/-/moon-made-of-cheese/12345/-/index.html", "/-/SLUG/ID/-/index.html"},
+		{"", ""},
+	}
+	for _, dat := range data {
+		got := PathShape(dat.path)
+		if got != dat.expect {
+			t.Errorf("PathShape(%q): expected %q, got %q", dat.path, dat.expect, got)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package discover
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bcampbell/scrapeomat/fetch"
+	"github.com/bcampbell/scrapeomat/sitemap"
+)
+
+// runSitemaps looks for article links in the sitemap files.
+// Sitemaps and articles disallowed by robots.txt are skipped (and counted
+// in Stats.DisallowedCount). Non-article links are recorded in report (if
+// not nil).
+func (disc *Discoverer) runSitemaps(client *http.Client, quit <-chan struct{}, report *Report) (LinkSet, error) {
+	locs := []string{}
+	for _, loc := range disc.Sitemaps {
+		if loc == "robots" {
+			robots := disc.Robots
+			if robots == nil {
+				// not obeying robots.txt, but can still use it to find sitemaps
+				robots = fetch.NewRobotsCache(client, disc.UserAgent)
+			}
+			listed := robots.Get(&disc.StartURL).Sitemaps
+			if len(listed) == 0 {
+				disc.ErrorLog.Printf("no sitemaps listed in robots.txt\n")
+			}
+			locs = append(locs, listed...)
+			continue
+		}
+		// allow sitemaps relative to the start url
+		u, err := disc.StartURL.Parse(loc)
+		if err != nil {
+			disc.ErrorLog.Printf("bad sitemap url %s: %s\n", loc, err)
+			continue
+		}
+		locs = append(locs, u.String())
+	}
+
+	w := sitemap.NewWalker(client)
+	w.UserAgent = disc.UserAgent
+	w.Retry = disc.Retry
+	w.MaxErrors = disc.BaseErrorThreshold
+	w.ErrorLog = disc.ErrorLog
+	w.InfoLog = disc.InfoLog
+	w.Robots = disc.Robots
+	if disc.SitemapMaxAge > 0 {
+		w.From = time.Now().Add(-disc.SitemapMaxAge)
+		w.FilterIndexes = true
+	}
+	urls, err := w.Walk(locs, quit)
+	disc.Stats.FetchCount += w.Stats.FetchCount
+	disc.Stats.ErrorCount += w.Stats.ErrorCount
+	disc.Stats.RetryCount += w.Stats.RetryCount
+	disc.Stats.GiveUpCount += w.Stats.GiveUpCount
+	disc.Stats.DisallowedCount += w.Stats.DisallowedCount
+	if err != nil {
+		return nil, err
+	}
+
+	arts := make(LinkSet)
+	disallowed := 0
+	for _, u := range urls {
+		cooked, err := disc.CookArticleURL(&disc.StartURL, u.Loc)
+		if err != nil {
+			if report != nil {
+				if u, err := disc.NormaliseURL(&disc.StartURL, u.Loc); err == nil && disc.IsHostGood(u.Host) {
+					report.addNonArticle(u)
+				}
+			}
+			continue
+		}
+		if disc.Robots != nil && !disc.Robots.Allowed(cooked) {
+			disallowed++
+			continue
+		}
+		arts[*cooked] = true
+	}
+	disc.Stats.DisallowedCount += disallowed
+	disc.InfoLog.Printf("Sitemaps: %d files, %d links, found %d articles (%d disallowed by robots.txt)\n", w.Stats.FetchCount, len(urls), len(arts), disallowed)
+	return arts, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+// Dry-run mode (the -dryrun flag): articles are written out as JSON
+// instead of going into the database.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/bcampbell/scrapeomat/slurp"
+	"github.com/bcampbell/scrapeomat/store"
+)
+
+var errDryRun = errors.New("not supported in dry-run mode")
+
+// jsonStore is a store.Store which writes stashed articles out as JSON
+// lines, as slurp.Msgs (the same format as the slurp API).
+// It remembers the URLs of articles written, so repeats can be detected,
+// but otherwise can't be read back.
+type jsonStore struct {
+	lock sync.Mutex
+	w    io.Writer
+	enc  *json.Encoder
+	// article urls -> id, for articles written so far
+	urls   map[string]int
+	nextID int
+}
+
+func newJSONStore(w io.Writer) *jsonStore {
+	return &jsonStore{
+		w:      w,
+		enc:    json.NewEncoder(w),
+		urls:   map[string]int{},
+		nextID: 1,
+	}
+}
+
+func (js *jsonStore) Close() {
+	if c, ok := js.w.(io.Closer); ok && js.w != os.Stdout {
+		c.Close()
+	}
+}
+
+func (js *jsonStore) Stash(arts ...*store.Article) ([]int, error) {
+	js.lock.Lock()
+	defer js.lock.Unlock()
+	ids := make([]int, 0, len(arts))
+	for _, art := range arts {
+		id := art.ID
+		if id == 0 {
+			id = js.nextID
+			js.nextID++
+		}
+		err := js.enc.Encode(slurp.Msg{Article: toSlurpArticle(art, id)})
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range art.URLs {
+			js.urls[u] = id
+		}
+		if art.CanonicalURL != "" {
+			js.urls[art.CanonicalURL] = id
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (js *jsonStore) AddURLs(artID int, urls []string) (int, error) {
+	js.lock.Lock()
+	defer js.lock.Unlock()
+	cnt := 0
+	for _, u := range urls {
+		if _, got := js.urls[u]; !got {
+			js.urls[u] = artID
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
+func (js *jsonStore) WhichAreNew(artURLs []string) ([]string, error) {
+	js.lock.Lock()
+	defer js.lock.Unlock()
+	newArts := []string{}
+	for _, u := range artURLs {
+		if _, got := js.urls[u]; !got {
+			newArts = append(newArts, u)
+		}
+	}
+	return newArts, nil
+}
+
+func (js *jsonStore) FindURLs(urls []string) ([]int, error) {
+	js.lock.Lock()
+	defer js.lock.Unlock()
+	seen := map[int]bool{}
+	out := []int{}
+	for _, u := range urls {
+		if id, got := js.urls[u]; got && !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+func (js *jsonStore) FetchCount(filt *store.Filter) (int, error) {
+	return 0, errDryRun
+}
+
+func (js *jsonStore) Fetch(filt *store.Filter) store.ArtIter {
+	return errIter{errDryRun}
+}
+
+func (js *jsonStore) FetchPublications() ([]store.Publication, error) {
+	return nil, errDryRun
+}
+
+func (js *jsonStore) FetchSummary(filt *store.Filter, group string) ([]store.DatePubCount, error) {
+	return nil, errDryRun
+}
+
+func (js *jsonStore) FetchArt(artID int) (*store.Article, error) {
+	return nil, errDryRun
+}
+
+// StashRun does nothing - runs aren't recorded in dry-run mode.
+func (js *jsonStore) StashRun(run *store.ScrapeRun) (int, error) {
+	return run.ID, nil
+}
+
+func (js *jsonStore) FetchRuns(scraper string, count int) ([]store.ScrapeRun, error) {
+	return []store.ScrapeRun{}, nil
+}
+
+// toSlurpArticle converts an article into the slurp wire format.
+func toSlurpArticle(art *store.Article, id int) *slurp.Article {
+	out := &slurp.Article{
+		ID:           id,
+		CanonicalURL: art.CanonicalURL,
+		URLs:         art.URLs,
+		Headline:     art.Headline,
+		Content:      art.Content,
+		Published:    art.Published,
+		Updated:      art.Updated,
+		Publication: slurp.Publication{
+			Code:   art.Publication.Code,
+			Name:   art.Publication.Name,
+			Domain: art.Publication.Domain,
+		},
+		Section: art.Section,
+	}
+	for _, a := range art.Authors {
+		out.Authors = append(out.Authors, slurp.Author{
+			Name:    a.Name,
+			RelLink: a.RelLink,
+			Email:   a.Email,
+			Twitter: a.Twitter,
+		})
+	}
+	for _, kw := range art.Keywords {
+		out.Keywords = append(out.Keywords, slurp.Keyword{Name: kw.Name, URL: kw.URL})
+	}
+	if art.Extra != nil {
+		out.Extra.RetweetCount = art.Extra.RetweetCount
+		out.Extra.FavoriteCount = art.Extra.FavoriteCount
+		out.Extra.Links = art.Extra.Links
+	}
+	return out
+}
+
+// errIter is an ArtIter which just returns an error
+type errIter struct {
+	err error
+}
+
+func (it errIter) Next() bool              { return false }
+func (it errIter) Article() *store.Article { return nil }
+func (it errIter) Err() error              { return it.err }
+func (it errIter) Close() error            { return nil }
+
+// dryRun runs the scrapers once (or on the -i list), writing the articles
+// to opts.outFile instead of the database.
+func dryRun(targetScrapers []Scraper, allScrapers map[string]Scraper) error {
+	var w io.Writer = os.Stdout
+	if opts.outFile != "" && opts.outFile != "-" {
+		outFile, err := os.Create(opts.outFile)
+		if err != nil {
+			return err
+		}
+		w = outFile
+	}
+	db := newJSONStore(w)
+	defer db.Close()
+
+	if opts.inputFile != "" {
+		artURLs, err := readURLList(opts.inputFile)
+		if err != nil {
+			return err
+		}
+		return runFromList(artURLs, targetScrapers, allScrapers, db)
+	}
+
+	if len(targetScrapers) == 0 {
+		return fmt.Errorf("no scrapers specified")
+	}
+	failCnt := 0
+	for _, scraper := range targetScrapers {
+		err := scraper.DoRun(db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR (%s): %s\n", scraper.Name(), err)
+			failCnt++
+		}
+	}
+	if failCnt > 0 {
+		return fmt.Errorf("%d scrapers failed", failCnt)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+// Explain mode (the -explain flag): runs a single article URL through a
+// scraper, showing what happens at each step. Nothing is stored.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bcampbell/arts/arts"
+	"github.com/bcampbell/scrapeomat/store"
+	"github.com/bcampbell/scrapeomat/store/sqlstore"
+)
+
+// Explainer is implemented by scrapers which can show their working for
+// a single article URL (see the -explain flag).
+type Explainer interface {
+	// Explain scrapes artURL without storing it, writing out the details
+	// of each step. db is used to check for existing copies (nil to skip).
+	Explain(artURL string, db store.Store, w io.Writer) error
+}
+
+// scrapeTrace records the steps taken by GenericScraper.scrapeArt
+type scrapeTrace struct {
+	fromArchive bool
+	status      string   // HTTP status of final response
+	chain       []string // urls visited (including redirects)
+	// as returned by arts.ExtractFromHTML (before any overrides)
+	extracted *arts.Article
+	art       *store.Article
+}
+
+// openExistingDB opens the database for -explain to check against, but
+// only if one is configured and it already exists - opening a new sqlite
+// db would create it. The schema is left alone, even if it's out of date.
+// Returns nil (and no error) if there's nothing to open.
+func openExistingDB(driver string, connStr string) (*sqlstore.SQLStore, error) {
+	if connStr == "" {
+		connStr = os.Getenv("SCRAPEOMAT_DB")
+	}
+	if driver == "" {
+		driver = os.Getenv("SCRAPEOMAT_DRIVER")
+	}
+	if connStr == "" {
+		return nil, nil
+	}
+	if driver == "" {
+		driver = "sqlite3"
+	}
+	if driver == "sqlite3" {
+		// connStr is a filename, possibly as a "file:" URI with options
+		fileName := strings.TrimPrefix(connStr, "file:")
+		if idx := strings.Index(fileName, "?"); idx != -1 {
+			fileName = fileName[:idx]
+		}
+		if _, err := os.Stat(fileName); err != nil {
+			return nil, nil
+		}
+	}
+	return sqlstore.NewExisting(driver, connStr)
+}
+
+// explain picks a scraper for artURL and has it explain the scraping.
+// With a single target scraper, that one is used regardless. Otherwise the
+// first one (by name) which accepts the URL is used.
+func explain(artURL string, targetScrapers []Scraper, allScrapers map[string]Scraper, db store.Store, w io.Writer) error {
+	candidates := append([]Scraper{}, targetScrapers...)
+	if len(candidates) == 0 {
+		for _, scraper := range allScrapers {
+			candidates = append(candidates, scraper)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Name() < candidates[j].Name() })
+
+	var picked Scraper
+	if len(candidates) == 1 {
+		picked = candidates[0]
+	} else {
+		for _, scraper := range candidates {
+			m, ok := scraper.(URLMatcher)
+			if !ok {
+				continue
+			}
+			if _, err := m.CookArticleURL(artURL); err != nil {
+				fmt.Fprintf(w, "(%s rejects url: %s)\n", scraper.Name(), err)
+				continue
+			}
+			picked = scraper
+			break
+		}
+	}
+	if picked == nil {
+		return fmt.Errorf("no scraper accepts %s", artURL)
+	}
+
+	e, ok := picked.(Explainer)
+	if !ok {
+		return fmt.Errorf("%s: scraper can't explain itself", picked.Name())
+	}
+	fmt.Fprintf(w, "scraper: %s\n", picked.Name())
+	return e.Explain(artURL, db, w)
+}
+
+// Explain shows the URL rules, fetching, extraction and acceptance
+// checks for a single article.
+func (scraper *GenericScraper) Explain(artURL string, db store.Store, w io.Writer) error {
+	disc := scraper.discoverer
+
+	fmt.Fprintf(w, "\nURL RULES\n")
+	fmt.Fprintf(w, "  url:        %s\n", artURL)
+	baseURL := disc.StartURL
+	normalised, err := disc.NormaliseURL(&baseURL, artURL)
+	if err != nil {
+		fmt.Fprintf(w, "  normalised: FAILED (%s)\n", err)
+	} else {
+		fmt.Fprintf(w, "  normalised: %s\n", normalised)
+		if disc.IsHostGood(normalised.Host) {
+			fmt.Fprintf(w, "  host:       %s (ok)\n", normalised.Host)
+		} else {
+			fmt.Fprintf(w, "  host:       %s (not accepted)\n", normalised.Host)
+		}
+		path := normalised.RequestURI()
+		matched := false
+		for _, pat := range disc.ArtPats {
+			if pat.MatchString(path) {
+				fmt.Fprintf(w, "  artpat:     %s (matches)\n", pat)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fmt.Fprintf(w, "  artpat:     none of %d match\n", len(disc.ArtPats))
+		}
+		for _, pat := range disc.XArtPats {
+			if pat.MatchString(path) {
+				fmt.Fprintf(w, "  xartpat:    %s (matches)\n", pat)
+			}
+		}
+	}
+	cooked, err := scraper.CookArticleURL(artURL)
+	if err != nil {
+		// carry on regardless - might be useful to see what we'd get
+		fmt.Fprintf(w, "  result:     REJECTED (%s)\n", err)
+		cooked = artURL
+	} else {
+		fmt.Fprintf(w, "  result:     accepted as %s\n", cooked)
+	}
+
+	err = scraper.Login()
+	if err != nil {
+		return err
+	}
+
+	// nothing is stored - not even in the archive
+	scraper.noArchive = true
+	defer func() { scraper.noArchive = false }()
+	tr := &scrapeTrace{}
+	art, scrapeErr := scraper.scrapeArt(cooked, tr)
+
+	fmt.Fprintf(w, "\nFETCH\n")
+	if tr.status != "" {
+		if tr.fromArchive {
+			fmt.Fprintf(w, "  source:     archive (%s)\n", scraper.archiveDir)
+		} else {
+			fmt.Fprintf(w, "  source:     http\n")
+		}
+		fmt.Fprintf(w, "  status:     %s\n", tr.status)
+		for i, u := range tr.chain[1:] {
+			fmt.Fprintf(w, "  redirect:   %s -> %s\n", tr.chain[i], u)
+		}
+	}
+
+	if tr.extracted != nil {
+		fmt.Fprintf(w, "\nEXTRACTED (by arts)\n")
+		a := *tr.extracted
+		a.Content = abbreviate(a.Content)
+		writeIndentedJSON(w, a)
+	}
+	if tr.art != nil {
+		fmt.Fprintf(w, "\nARTICLE\n")
+		a := *tr.art
+		a.Content = abbreviate(a.Content)
+		writeIndentedJSON(w, a)
+	}
+
+	fmt.Fprintf(w, "\nRESULT\n")
+	switch e := scrapeErr.(type) {
+	case nil:
+		fmt.Fprintf(w, "  ok (%d chars of content)\n", textLength(art.Content))
+	case *rejectError:
+		fmt.Fprintf(w, "  REJECTED: %s (%s)\n", e.reason, e.detail)
+	default:
+		fmt.Fprintf(w, "  FAILED: %s\n", scrapeErr)
+	}
+
+	fmt.Fprintf(w, "\nDATABASE\n")
+	if db == nil {
+		fmt.Fprintf(w, "  not checked (no db)\n")
+		return nil
+	}
+	urls := []string{cooked}
+	if tr.art != nil {
+		urls = tr.art.URLs
+	} else if len(tr.chain) > 0 {
+		urls = tr.chain
+	}
+	ids, err := db.FindURLs(urls)
+	if err != nil {
+		return fmt.Errorf("FindURLs() failed: %s", err)
+	}
+	if len(ids) == 0 {
+		fmt.Fprintf(w, "  not in db\n")
+	} else {
+		fmt.Fprintf(w, "  already in db (ids %v)\n", ids)
+	}
+	return nil
+}
+
+// abbreviate cuts s down to a displayable length, noting how much was
+// dropped.
+func abbreviate(s string) string {
+	const maxLen = 300
+	n := utf8.RuneCountInString(s)
+	if n <= maxLen {
+		return s
+	}
+	return fmt.Sprintf("%s... (%d more chars)", string([]rune(s)[:maxLen]), n-maxLen)
+}
+
+func writeIndentedJSON(w io.Writer, v interface{}) {
+	fmt.Fprintf(w, "  ")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("  ", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(w, "(%s)\n", err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplainNoArchive(t *testing.T) {
+	srv := newArtServer(0)
+	defer srv.Close()
+
+	archiveDir, err := ioutil.TempDir("", "explaintest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(archiveDir)
+
+	scraper := newTestScraper(t, srv, "0", 1)
+	scraper.archiveDir = archiveDir
+
+	var out strings.Builder
+	err = scraper.Explain(srv.URL+"/art/1", nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "ok (") {
+		t.Errorf("expected article to be scraped OK, got:\n%s", out.String())
+	}
+	files, err := ioutil.ReadDir(archiveDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) > 0 {
+		t.Errorf("expected empty archive dir, got %d files", len(files))
+	}
+
+	// (but a normal scrape still archives)
+	if _, err := scraper.ScrapeArt(srv.URL + "/art/2"); err != nil {
+		t.Fatal(err)
+	}
+	files, _ = ioutil.ReadDir(archiveDir)
+	if len(files) == 0 {
+		t.Errorf("expected normal scrape to archive")
+	}
+}
+
+func TestOpenExistingDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "explaintest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// missing db - mustn't be created
+	missing := filepath.Join(dir, "missing.db")
+	ss, err := openExistingDB("sqlite3", missing)
+	if ss != nil || err != nil {
+		t.Errorf("missing db: expected nothing opened, got %v, %v", ss, err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("missing db: was created")
+	}
+
+	// db without a schema - mustn't be set up
+	empty := filepath.Join(dir, "empty.db")
+	if err := ioutil.WriteFile(empty, []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ss, err = openExistingDB("sqlite3", empty)
+	if err == nil {
+		ss.Close()
+		t.Errorf("empty db: expected error")
+	}
+	if fi, err := os.Stat(empty); err != nil || fi.Size() != 0 {
+		t.Errorf("empty db: was modified")
+	}
+}
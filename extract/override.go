@@ -0,0 +1,230 @@
+package extract
+
+// Per-site fixes for the generic arts extractor, using CSS selectors to
+// pick out the parts of an article it gets wrong.
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/bcampbell/arts/arts"
+	"github.com/bcampbell/fuzzytime"
+	"github.com/bcampbell/htmlutil"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// OverrideDef holds the selectors used to override (or fill in) fields
+// extracted by arts. Each one is optional.
+type OverrideDef struct {
+	// HeadlineSel and SectionSel take the text of the first match
+	HeadlineSel string
+	SectionSel  string
+	// ContentSel takes the html of all the matches
+	ContentSel string
+	// ContentCruftSel picks out stuff to remove from the content (eg
+	// comment boxes, share buttons). Applies even without ContentSel.
+	ContentCruftSel string
+	// AuthorSel and KeywordSel take each match as a separate author or
+	// keyword (using the link, if the match is an <a>)
+	AuthorSel  string
+	KeywordSel string
+	// PublishedSel takes the date from the first match (from a
+	// datetime or content attribute if present, else the text)
+	PublishedSel string
+}
+
+// Override applies an OverrideDef to scraped articles.
+type Override struct {
+	headline     cascadia.Selector
+	section      cascadia.Selector
+	content      cascadia.Selector
+	contentCruft cascadia.Selector
+	author       cascadia.Selector
+	keyword      cascadia.Selector
+	published    cascadia.Selector
+}
+
+// NewOverride compiles the selectors in def.
+func NewOverride(def OverrideDef) (*Override, error) {
+	o := &Override{}
+	sels := []struct {
+		name string
+		src  string
+		dest *cascadia.Selector
+	}{
+		{"headlinesel", def.HeadlineSel, &o.headline},
+		{"sectionsel", def.SectionSel, &o.section},
+		{"contentsel", def.ContentSel, &o.content},
+		{"contentcruftsel", def.ContentCruftSel, &o.contentCruft},
+		{"authorsel", def.AuthorSel, &o.author},
+		{"keywordsel", def.KeywordSel, &o.keyword},
+		{"publishedsel", def.PublishedSel, &o.published},
+	}
+	for _, s := range sels {
+		if s.src == "" {
+			continue
+		}
+		sel, err := cascadia.Compile(s.src)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s: %s", s.name, err)
+		}
+		*s.dest = sel
+	}
+	return o, nil
+}
+
+// Empty returns true if there's nothing to override.
+func (o *Override) Empty() bool {
+	return o.headline == nil && o.section == nil && o.content == nil &&
+		o.contentCruft == nil && o.author == nil && o.keyword == nil &&
+		o.published == nil
+}
+
+// Apply overrides the fields in art using the selectors, matching against
+// the page it was extracted from. Selectors which don't match leave the
+// field as it was.
+func (o *Override) Apply(art *arts.Article, rawHTML []byte, artURL string) error {
+	if o.Empty() {
+		return nil
+	}
+	root, err := arts.ParseHTML(rawHTML)
+	if err != nil {
+		return err
+	}
+	baseURL, err := url.Parse(artURL)
+	if err != nil {
+		return err
+	}
+
+	if o.headline != nil {
+		if n := o.headline.MatchFirst(root); n != nil {
+			if txt := compressSpace(htmlutil.TextContent(n)); txt != "" {
+				art.Headline = txt
+			}
+		}
+	}
+
+	if o.section != nil {
+		if n := o.section.MatchFirst(root); n != nil {
+			if txt := compressSpace(htmlutil.TextContent(n)); txt != "" {
+				art.Section = txt
+			}
+		}
+	}
+
+	if o.published != nil {
+		if n := o.published.MatchFirst(root); n != nil {
+			if dt := parseDate(n); !dt.Empty() {
+				art.Published = dt.ISOFormat()
+			}
+		}
+	}
+
+	if o.author != nil {
+		authors := []arts.Author{}
+		for _, n := range o.author.MatchAll(root) {
+			name := compressSpace(htmlutil.TextContent(n))
+			if name == "" {
+				continue
+			}
+			authors = append(authors, arts.Author{Name: name, RelLink: linkFrom(n, baseURL)})
+		}
+		if len(authors) > 0 {
+			art.Authors = authors
+		}
+	}
+
+	if o.keyword != nil {
+		keywords := []arts.Keyword{}
+		for _, n := range o.keyword.MatchAll(root) {
+			name := compressSpace(htmlutil.TextContent(n))
+			if name == "" {
+				continue
+			}
+			keywords = append(keywords, arts.Keyword{Name: name, URL: linkFrom(n, baseURL)})
+		}
+		if len(keywords) > 0 {
+			art.Keywords = keywords
+		}
+	}
+
+	if o.content != nil {
+		nodes := o.content.MatchAll(root)
+		if len(nodes) > 0 {
+			art.Content = o.renderContent(nodes)
+		}
+	} else if o.contentCruft != nil && art.Content != "" {
+		// just tidy up what arts found
+		ctx := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+		nodes, err := html.ParseFragment(strings.NewReader(art.Content), ctx)
+		if err == nil {
+			art.Content = o.renderContent(nodes)
+		}
+	}
+	return nil
+}
+
+// renderContent removes any cruft from nodes, and renders them as
+// sanitised html
+func (o *Override) renderContent(nodes []*html.Node) string {
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		if o.contentCruft != nil {
+			for _, cruft := range o.contentCruft.MatchAll(n) {
+				if cruft.Parent != nil {
+					cruft.Parent.RemoveChild(cruft)
+				}
+			}
+			if o.contentCruft.Match(n) {
+				continue
+			}
+		}
+		if n.Type == html.ElementNode {
+			htmlutil.Sanitise(n)
+		}
+		html.Render(&buf, n)
+	}
+	return buf.String()
+}
+
+// parseDate gets a date from a node, trying the machine-readable
+// attributes first.
+func parseDate(n *html.Node) fuzzytime.DateTime {
+	for _, attr := range []string{"datetime", "content"} {
+		if val := htmlutil.GetAttr(n, attr); val != "" {
+			dt, _, err := fuzzytime.Extract(val)
+			if err == nil && !dt.Empty() {
+				return dt
+			}
+		}
+	}
+	dt, _, err := fuzzytime.Extract(htmlutil.TextContent(n))
+	if err != nil {
+		return fuzzytime.DateTime{}
+	}
+	return dt
+}
+
+// linkFrom returns the absolute url a node links to (if it's an <a>)
+func linkFrom(n *html.Node, baseURL *url.URL) string {
+	if n.DataAtom != atom.A {
+		return ""
+	}
+	href := htmlutil.GetAttr(n, "href")
+	if href == "" {
+		return ""
+	}
+	u, err := baseURL.Parse(href)
+	if err != nil {
+		return ""
+	}
+	return u.String()
+}
+
+func compressSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,85 @@
+package extract
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bcampbell/arts/arts"
+)
+
+const testPage = `<html><head><title>Wrong headline - Example News</title></head>
+<body>
+<h1 class="title">  Moon made
+ of cheese </h1>
+<div class="crumbs"><a href="/science">Science</a></div>
+<p class="byline">By <a href="/staff/bob" class="author">Bob Smith</a> and <span class="author">Fred Door</span></p>
+<span class="pubdate" data-x="1" datetime="2017-01-05T10:30:00Z">Yesterday</span>
+<div class="body">
+<p>Scientists are astonished.</p>
+<div class="comments">Comment: first!</div>
+<p>More to follow.</p>
+</div>
+<ul class="tags"><li><a href="/tags/moon">Moon</a></li><li><a href="/tags/cheese">Cheese</a></li></ul>
+</body></html>`
+
+func TestOverride(t *testing.T) {
+	o, err := NewOverride(OverrideDef{
+		HeadlineSel:     "h1.title",
+		SectionSel:      ".crumbs a",
+		ContentSel:      "div.body",
+		ContentCruftSel: ".comments",
+		AuthorSel:       ".author",
+		KeywordSel:      ".tags a",
+		PublishedSel:    ".pubdate",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	art := &arts.Article{Headline: "Wrong headline", Content: "<p>wrong</p>"}
+	err = o.Apply(art, []byte(testPage), "http://example.com/news/moon")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if art.Headline != "Moon made of cheese" {
+		t.Errorf("headline: got %q", art.Headline)
+	}
+	if art.Section != "Science" {
+		t.Errorf("section: got %q", art.Section)
+	}
+	if art.Published != "2017-01-05T10:30:00Z" {
+		t.Errorf("published: got %q", art.Published)
+	}
+	if len(art.Authors) != 2 || art.Authors[0].Name != "Bob Smith" ||
+		art.Authors[0].RelLink != "http://example.com/staff/bob" || art.Authors[1].Name != "Fred Door" {
+		t.Errorf("authors: got %v", art.Authors)
+	}
+	if len(art.Keywords) != 2 || art.Keywords[1].Name != "Cheese" || art.Keywords[1].URL != "http://example.com/tags/cheese" {
+		t.Errorf("keywords: got %v", art.Keywords)
+	}
+	if !strings.Contains(art.Content, "astonished") || strings.Contains(art.Content, "first!") {
+		t.Errorf("content: got %q", art.Content)
+	}
+
+	// cruft removal on its own, and non-matching selectors leave things alone
+	o, err = NewOverride(OverrideDef{ContentCruftSel: ".comments", HeadlineSel: "h2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	art = &arts.Article{Headline: "Keep me", Content: `<p>Hello.</p><div class="comments">Comment: first!</div>`}
+	err = o.Apply(art, []byte(testPage), "http://example.com/news/moon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if art.Headline != "Keep me" {
+		t.Errorf("headline: got %q", art.Headline)
+	}
+	if art.Content != "<p>Hello.</p>" {
+		t.Errorf("content: got %q", art.Content)
+	}
+
+	if _, err := NewOverride(OverrideDef{AuthorSel: "p["}); err == nil {
+		t.Errorf("expected error for bad selector")
+	}
+}
@@ -0,0 +1,163 @@
+// Package feed reads RSS and Atom feeds, for finding article links.
+//
+// It's deliberately minimal - just the item links, titles and dates.
+// RSS 2.0, RSS 1.0 (RDF) and Atom are handled.
+package feed
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Item is a single entry in a feed.
+type Item struct {
+	Link  string
+	Title string
+	// Published is the publication date of the item (zero if none)
+	Published time.Time
+	// Naive is set if the feed gave no timezone for Published (it's
+	// parsed as UTC, but the publisher probably meant local time).
+	Naive bool
+}
+
+// the raw xml, covering RSS 2.0 (<rss><channel><item>), RSS 1.0
+// (<rdf:RDF><item>) and Atom (<feed><entry>).
+type rawFeed struct {
+	XMLName      xml.Name
+	ChannelItems []rawItem  `xml:"channel>item"`
+	Items        []rawItem  `xml:"item"`
+	Entries      []rawEntry `xml:"entry"`
+}
+
+type rawItem struct {
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+	GUID  struct {
+		Value       string `xml:",chardata"`
+		IsPermaLink string `xml:"isPermaLink,attr"`
+	} `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+	// dc:date (RSS 1.0)
+	Date string `xml:"date"`
+}
+
+type rawEntry struct {
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+}
+
+// Parse reads an RSS or Atom feed.
+func Parse(in io.Reader) ([]Item, error) {
+	raw := rawFeed{}
+	dec := xml.NewDecoder(in)
+	// (feeds in the wild often use non-utf8 encodings)
+	dec.CharsetReader = charset.NewReaderLabel
+	err := dec.Decode(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("decode failed: %s", err)
+	}
+	switch strings.ToLower(raw.XMLName.Local) {
+	case "rss", "rdf", "feed":
+	default:
+		return nil, fmt.Errorf("not a feed (<%s>)", raw.XMLName.Local)
+	}
+
+	items := []Item{}
+	for _, ri := range append(raw.ChannelItems, raw.Items...) {
+		item := Item{
+			Title: strings.TrimSpace(ri.Title),
+			Link:  strings.TrimSpace(ri.Link),
+		}
+		if item.Link == "" && ri.GUID.IsPermaLink != "false" {
+			// guid is a permalink unless told otherwise
+			item.Link = strings.TrimSpace(ri.GUID.Value)
+		}
+		if ri.PubDate != "" {
+			item.Published, item.Naive, _ = ParseDate(ri.PubDate)
+		} else if ri.Date != "" {
+			item.Published, item.Naive, _ = ParseDate(ri.Date)
+		}
+		if item.Link != "" {
+			items = append(items, item)
+		}
+	}
+	for _, e := range raw.Entries {
+		item := Item{Title: strings.TrimSpace(e.Title)}
+		for _, l := range e.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				item.Link = strings.TrimSpace(l.Href)
+				break
+			}
+		}
+		if e.Published != "" {
+			item.Published, item.Naive, _ = ParseDate(e.Published)
+		} else if e.Updated != "" {
+			item.Published, item.Naive, _ = ParseDate(e.Updated)
+		}
+		if item.Link != "" {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// ParseDate parses a feed timestamp - RFC822-ish for RSS, RFC3339 for
+// Atom (and RSS 1.0).
+// Timestamps without a timezone are parsed as UTC, with naive set. So are
+// ones with a zone abbreviation Go doesn't know (eg "EST", "BST"), which
+// would otherwise be treated as UTC offsets of zero.
+func ParseDate(s string) (t time.Time, naive bool, err error) {
+	s = strings.TrimSpace(s)
+	fmts := []string{
+		time.RFC1123Z,
+		time.RFC1123,
+		"Mon, 2 Jan 2006 15:04:05 -0700",
+		"Mon, 2 Jan 2006 15:04:05 MST",
+		"Mon, 2 Jan 2006 15:04 -0700",
+		"2 Jan 2006 15:04:05 -0700",
+		"Mon, 02 Jan 06 15:04:05 -0700",
+		time.RFC3339,
+	}
+	for _, layout := range fmts {
+		t, err = time.Parse(layout, s)
+		if err == nil {
+			if name, offset := t.Zone(); offset == 0 && !isUTCName(name) {
+				// unknown abbreviation - we've got the local time, but no
+				// idea where
+				return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC), true, nil
+			}
+			return t, false, nil
+		}
+	}
+	naiveFmts := []string{
+		"2006-01-02T15:04:05",
+		"2006-01-02",
+	}
+	for _, layout := range naiveFmts {
+		t, err = time.Parse(layout, s)
+		if err == nil {
+			return t, true, nil
+		}
+	}
+	return t, false, err
+}
+
+// isUTCName returns true for zone names which really do mean UTC
+// (including numeric offsets, eg "+0000").
+func isUTCName(name string) bool {
+	switch name {
+	case "", "UTC", "GMT":
+		return true
+	}
+	return name[0] == '+' || name[0] == '-'
+}
@@ -0,0 +1,118 @@
+package feed
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	testData := []struct {
+		name   string
+		src    string
+		expect []Item
+	}{
+		{
+			"rss2",
+			`<?xml version="1.0" encoding="ISO-8859-1"?>
+<rss version="2.0"><channel><title>Example News</title><link>http://example.com/</link>
+<item><title>Moon made of cheese</title><link>http://example.com/news/moon-cheese</link><pubDate>Sat, 01 May 2021 10:30:00 +0100</pubDate></item>
+<item><title>Caf` + "\xe9" + ` opens</title><guid>http://example.com/news/cafe-opens</guid><pubDate>Sun, 2 May 2021 08:00:00 GMT</pubDate></item>
+<item><title>No link</title><guid isPermaLink="false">12345</guid></item>
+</channel></rss>`,
+			[]Item{
+				{"http://example.com/news/moon-cheese", "Moon made of cheese", time.Date(2021, 5, 1, 9, 30, 0, 0, time.UTC), false},
+				{"http://example.com/news/cafe-opens", "Café opens", time.Date(2021, 5, 2, 8, 0, 0, 0, time.UTC), false},
+			},
+		},
+		{
+			"atom",
+			`<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>Example News</title>
+<entry><title>Moon made of cheese</title>
+<link rel="self" href="http://example.com/feeds/1"/>
+<link rel="alternate" href="http://example.com/news/moon-cheese"/>
+<published>2021-05-01T10:30:00+01:00</published><updated>2021-05-03T00:00:00Z</updated></entry>
+<entry><title>Undated</title><link href="http://example.com/news/undated"/></entry>
+<entry><title>Naive</title><link href="http://example.com/news/naive"/><published>2021-05-01T10:30:00</published></entry>
+</feed>`,
+			[]Item{
+				{"http://example.com/news/moon-cheese", "Moon made of cheese", time.Date(2021, 5, 1, 9, 30, 0, 0, time.UTC), false},
+				{"http://example.com/news/undated", "Undated", time.Time{}, false},
+				{"http://example.com/news/naive", "Naive", time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC), true},
+			},
+		},
+		{
+			"rss1",
+			`<?xml version="1.0"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel><title>Example News</title></channel>
+<item><title>Moon made of cheese</title><link>http://example.com/news/moon-cheese</link><dc:date>2021-05-01T09:30:00Z</dc:date></item>
+</rdf:RDF>`,
+			[]Item{
+				{"http://example.com/news/moon-cheese", "Moon made of cheese", time.Date(2021, 5, 1, 9, 30, 0, 0, time.UTC), false},
+			},
+		},
+	}
+
+	for _, dat := range testData {
+		got, err := Parse(strings.NewReader(dat.src))
+		if err != nil {
+			t.Errorf("%s: %s", dat.name, err)
+			continue
+		}
+		if len(got) != len(dat.expect) {
+			t.Errorf("%s: expected %d items, got %d (%v)", dat.name, len(dat.expect), len(got), got)
+			continue
+		}
+		for i, exp := range dat.expect {
+			item := got[i]
+			if item.Link != exp.Link || item.Title != exp.Title || !item.Published.Equal(exp.Published) || item.Naive != exp.Naive {
+				t.Errorf("%s: item %d: expected %v, got %v", dat.name, i, exp, item)
+			}
+		}
+	}
+
+	if _, err := Parse(strings.NewReader(`<html><body>Not a feed</body></html>`)); err == nil {
+		t.Errorf("expected error for html")
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	// (a zone abbreviation matching the local zone would be recognised)
+	saved := time.Local
+	defer func() { time.Local = saved }()
+	time.Local = time.UTC
+
+	est := time.FixedZone("", -5*60*60)
+	testData := []struct {
+		in          string
+		expect      time.Time
+		expectNaive bool
+	}{
+		{"Sat, 01 May 2021 10:30:00 +0100", time.Date(2021, 5, 1, 9, 30, 0, 0, time.UTC), false},
+		{"Sat, 01 May 2021 10:30:00 -0500", time.Date(2021, 5, 1, 10, 30, 0, 0, est), false},
+		{"Sat, 01 May 2021 10:30:00 +0000", time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC), false},
+		{"Sat, 01 May 2021 10:30:00 GMT", time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC), false},
+		{"2021-05-01T10:30:00Z", time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC), false},
+		{"2021-05-01T10:30:00+00:00", time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC), false},
+		// unknown abbreviations - leave it to the caller to decide the zone
+		{"Sat, 01 May 2021 10:30:00 EST", time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC), true},
+		{"Sat, 01 May 2021 10:30:00 BST", time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC), true},
+		{"2021-05-01T10:30:00", time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC), true},
+		{"2021-05-01", time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC), true},
+	}
+	for _, dat := range testData {
+		got, naive, err := ParseDate(dat.in)
+		if err != nil {
+			t.Errorf("%s: %s", dat.in, err)
+			continue
+		}
+		if !got.Equal(dat.expect) || naive != dat.expectNaive {
+			t.Errorf("%s: expected %s (naive=%v), got %s (naive=%v)", dat.in, dat.expect, dat.expectNaive, got, naive)
+		}
+	}
+	if _, _, err := ParseDate("last tuesday"); err == nil {
+		t.Errorf("expected error for bad date")
+	}
+}
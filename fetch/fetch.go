@@ -0,0 +1,212 @@
+package fetch
+
+// Retrying HTTP fetches, for riding out the transient failures which are
+// routine on news sites (overloaded servers, rate limiting, dropped
+// connections etc).
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Policy controls how transient failures are retried.
+type Policy struct {
+	// MaxRetries is the number of retries to attempt after the initial
+	// request fails (0 = no retries).
+	MaxRetries int
+	// BaseDelay is the delay before the first retry. It doubles for
+	// each subsequent retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries. If a server asks us to
+	// wait longer than this (via Retry-After), we give up instead.
+	MaxDelay time.Duration
+}
+
+// DefaultPolicy is a reasonable retry policy for most sites.
+var DefaultPolicy = Policy{
+	MaxRetries: 3,
+	BaseDelay:  2 * time.Second,
+	MaxDelay:   2 * time.Minute,
+}
+
+// ErrQuit is returned if a quit request arrives while waiting to retry.
+var ErrQuit = errors.New("quit requested")
+
+// QuitContext returns a context which is cancelled when quit is closed,
+// so requests in progress (or waiting on a PoliteTripper) can be abandoned
+// promptly. The caller must call cancel once finished with the context.
+func QuitContext(quit <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if quit != nil {
+		go func() {
+			select {
+			case <-quit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
+
+// quitRequested returns true if quit has been closed.
+func quitRequested(quit <-chan struct{}) bool {
+	select {
+	case <-quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Result describes the retrying done for a request.
+type Result struct {
+	// Retries is the number of retries made
+	Retries int
+	// GaveUp is set if the request was retried, but was still failing
+	// transiently when we stopped. (A failure with no retries, eg if
+	// MaxRetries is 0, is just a failure).
+	GaveUp bool
+}
+
+// IsTransientStatus returns true for HTTP status codes which are likely to
+// go away if we try again later.
+func IsTransientStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, // 408
+		http.StatusTooManyRequests,    // 429
+		http.StatusBadGateway,         // 502
+		http.StatusServiceUnavailable, // 503
+		http.StatusGatewayTimeout:     // 504
+		return true
+	}
+	return false
+}
+
+// IsTransientError returns true for transport errors which are worth
+// retrying (timeouts, connection resets and the like).
+func IsTransientError(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter decodes a Retry-After header, which can hold either a
+// number of seconds or a HTTP date.
+func parseRetryAfter(val string, now time.Time) (time.Duration, bool) {
+	if val == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(val); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		d := t.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// delay returns the backoff before the given retry (0-based).
+func (p *Policy) delay(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < retry; i++ {
+		d *= 2
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// Do performs a request, retrying on transient failures.
+// The request must be repeatable (ie no body, or GetBody set).
+// If the retries run out on a transient HTTP status, the final response is
+// returned (with Result.GaveUp set) so the caller can handle it as usual.
+// A quit request while waiting to retry returns ErrQuit, as does a failed
+// request once quit has been closed (eg if the request context was
+// cancelled by QuitContext).
+func (p *Policy) Do(c *http.Client, req *http.Request, quit <-chan struct{}) (*http.Response, Result, error) {
+	res := Result{}
+	for {
+		resp, err := c.Do(req)
+
+		var wait time.Duration
+		if err != nil {
+			if quitRequested(quit) {
+				return nil, res, ErrQuit
+			}
+			if !IsTransientError(err) {
+				return nil, res, err
+			}
+			wait = p.delay(res.Retries)
+		} else {
+			if !IsTransientStatus(resp.StatusCode) {
+				return resp, res, nil
+			}
+			wait = p.delay(res.Retries)
+			if ra, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if p.MaxDelay > 0 && ra > p.MaxDelay {
+					// server wants us to back off for longer than we're
+					// prepared to wait
+					res.GaveUp = res.Retries > 0
+					return resp, res, nil
+				}
+				if ra > wait {
+					wait = ra
+				}
+			}
+		}
+
+		if res.Retries >= p.MaxRetries {
+			res.GaveUp = res.Retries > 0
+			return resp, res, err
+		}
+
+		// discard the failed response before trying again
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, res, err
+			}
+		}
+
+		select {
+		case <-quit:
+			return nil, res, ErrQuit
+		case <-req.Context().Done():
+			return nil, res, req.Context().Err()
+		case <-time.After(wait):
+		}
+		res.Retries++
+	}
+}
@@ -0,0 +1,99 @@
+package fetch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// server which fails with the given status codes before succeeding
+func flakyServer(codes []int, retryAfter string) (*httptest.Server, *int) {
+	cnt := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cnt < len(codes) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(codes[cnt])
+			cnt++
+			return
+		}
+		cnt++
+		w.Write([]byte("ok"))
+	}))
+	return srv, &cnt
+}
+
+func TestRetry(t *testing.T) {
+	policy := Policy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	testData := []struct {
+		codes        []int
+		retryAfter   string
+		expectStatus int
+		expectRes    Result
+	}{
+		{nil, "", 200, Result{Retries: 0}},
+		{[]int{503, 502}, "", 200, Result{Retries: 2}},
+		{[]int{429, 429, 429, 429, 429}, "", 429, Result{Retries: 3, GaveUp: true}},
+		// not transient - no retry
+		{[]int{404}, "", 404, Result{Retries: 0}},
+		// server wants us to wait too long
+		{[]int{503}, "3600", 503, Result{Retries: 0}},
+		{[]int{503, 503}, "", 200, Result{Retries: 2}},
+		{[]int{503}, "0", 200, Result{Retries: 1}},
+	}
+
+	for _, dat := range testData {
+		srv, _ := flakyServer(dat.codes, dat.retryAfter)
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		resp, res, err := policy.Do(http.DefaultClient, req, nil)
+		srv.Close()
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", dat.codes, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != dat.expectStatus {
+			t.Errorf("%v: got status %d, expected %d", dat.codes, resp.StatusCode, dat.expectStatus)
+		}
+		if res != dat.expectRes {
+			t.Errorf("%v: got %+v, expected %+v", dat.codes, res, dat.expectRes)
+		}
+	}
+}
+
+func TestNoRetries(t *testing.T) {
+	policy := Policy{MaxRetries: 0, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	srv, cnt := flakyServer([]int{503}, "")
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	resp, res, err := policy.Do(http.DefaultClient, req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 503 || *cnt != 1 {
+		t.Errorf("expected a single 503, got %d (after %d requests)", resp.StatusCode, *cnt)
+	}
+	// nothing retried, so nothing given up on
+	if res != (Result{}) {
+		t.Errorf("got %+v, expected no retries or give-up", res)
+	}
+}
+
+func TestRetryQuit(t *testing.T) {
+	policy := Policy{MaxRetries: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	srv, _ := flakyServer([]int{503}, "")
+	defer srv.Close()
+
+	quit := make(chan struct{})
+	close(quit)
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	_, _, err := policy.Do(http.DefaultClient, req, quit)
+	if err != ErrQuit {
+		t.Errorf("expected ErrQuit, got %v", err)
+	}
+}
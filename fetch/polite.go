@@ -0,0 +1,81 @@
+package fetch
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PoliteTripper is a http.RoundTripper which imposes a minimum delay
+// between requests to the same host.
+// It's based on the one in github.com/bcampbell/arts/util, but allows the
+// delay to be raised for individual hosts (eg to honour robots.txt
+// Crawl-delay).
+//
+//	c := &http.Client{
+//		Transport: NewPoliteTripper(),
+//	}
+type PoliteTripper struct {
+	// PerHostDelay is the default minimum delay between requests to a host
+	PerHostDelay time.Duration
+	// Transport performs the actual requests (nil means http.DefaultTransport)
+	Transport http.RoundTripper
+
+	lock      sync.Mutex
+	prevTime  map[string]time.Time
+	hostDelay map[string]time.Duration
+}
+
+func NewPoliteTripper() *PoliteTripper {
+	return &PoliteTripper{
+		PerHostDelay: 1 * time.Second,
+		prevTime:     make(map[string]time.Time),
+		hostDelay:    make(map[string]time.Duration),
+	}
+}
+
+// SetHostDelay sets the delay for a single host, overriding PerHostDelay
+// if it's longer.
+func (pt *PoliteTripper) SetHostDelay(host string, delay time.Duration) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	pt.hostDelay[host] = delay
+}
+
+// returns the delay to use for a host (lock must be held)
+func (pt *PoliteTripper) delayFor(host string) time.Duration {
+	if d, got := pt.hostDelay[host]; got && d > pt.PerHostDelay {
+		return d
+	}
+	return pt.PerHostDelay
+}
+
+func (pt *PoliteTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := pt.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	host := req.URL.Host
+	for {
+		pt.lock.Lock()
+		prev, _ := pt.prevTime[host]
+		elapsed := time.Since(prev)
+		delay := pt.delayFor(host)
+
+		if elapsed >= delay {
+			// OK - go!
+			pt.prevTime[host] = time.Now()
+			pt.lock.Unlock()
+			return transport.RoundTrip(req)
+		}
+		pt.lock.Unlock()
+
+		// sleep until we think the expected time has passed
+		// (but some other request might get in first, hence the loop)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay - elapsed):
+		}
+	}
+}
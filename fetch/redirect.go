@@ -0,0 +1,99 @@
+package fetch
+
+// Keeping track of redirects.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// MaxRedirects is the number of redirects CheckRedirect will follow.
+const MaxRedirects = 10
+
+// max amount of a redirect response body to keep
+const maxRedirectBody = 1 << 20
+
+// Hop is a redirect response followed during a request.
+type Hop struct {
+	// Response is the redirect response. Its Request field holds the
+	// request which caused it (ie Response.Request.URL is the URL which
+	// redirected).
+	Response *http.Response
+	// Body is the body of the redirect response (the client closes the
+	// original before following the redirect).
+	Body []byte
+}
+
+// RedirectLog records the redirects followed during a request.
+type RedirectLog struct {
+	Hops []Hop
+}
+
+type redirectLogKey struct{}
+
+// WithRedirectLog returns a copy of req which will record the redirects it
+// follows (if the client uses CheckRedirect).
+func WithRedirectLog(req *http.Request) (*http.Request, *RedirectLog) {
+	rl := &RedirectLog{}
+	return req.WithContext(context.WithValue(req.Context(), redirectLogKey{}, rl)), rl
+}
+
+// CheckRedirect is a http.Client CheckRedirect hook. It stops after
+// MaxRedirects redirects, and records each hop for requests set up with
+// WithRedirectLog().
+func CheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= MaxRedirects {
+		return errors.New("too many redirects")
+	}
+	rl, ok := req.Context().Value(redirectLogKey{}).(*RedirectLog)
+	if !ok || req.Response == nil {
+		return nil
+	}
+	if len(via) == 1 {
+		// first redirect of a new attempt (we might be being retried)
+		rl.Hops = nil
+	}
+	resp := req.Response
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRedirectBody))
+	if err != nil {
+		body = nil
+	}
+	// leave something for the client to read and close
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	rl.Hops = append(rl.Hops, Hop{Response: resp, Body: body})
+	return nil
+}
+
+// Responses returns the redirect responses, with their bodies reset so
+// they can be read (eg for archiving).
+func (rl *RedirectLog) Responses() []*http.Response {
+	out := make([]*http.Response, len(rl.Hops))
+	for i, hop := range rl.Hops {
+		resp := hop.Response
+		resp.Body = ioutil.NopCloser(bytes.NewReader(hop.Body))
+		out[i] = resp
+	}
+	return out
+}
+
+// RedirectChain returns the URLs visited to get a response, starting with
+// the URL originally requested and ending with the final one.
+// It relies on the Request.Response links set up by http.Client when
+// following redirects.
+func RedirectChain(resp *http.Response) []string {
+	chain := []string{}
+	req := resp.Request
+	for req != nil {
+		chain = append([]string{req.URL.String()}, chain...)
+		if req.Response == nil {
+			break
+		}
+		req = req.Response.Request
+	}
+	return chain
+}
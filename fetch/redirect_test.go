@@ -0,0 +1,72 @@
+package fetch
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/moved", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/final", http.StatusFound)
+	})
+	mux.HandleFunc("/final", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("final"))
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := &http.Client{CheckRedirect: CheckRedirect}
+
+	req, err := http.NewRequest("GET", srv.URL+"/old", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, rl := WithRedirectLog(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	chain := RedirectChain(resp)
+	expect := []string{srv.URL + "/old", srv.URL + "/moved", srv.URL + "/final"}
+	if len(chain) != len(expect) {
+		t.Fatalf("chain: expected %v, got %v", expect, chain)
+	}
+	for i := range expect {
+		if chain[i] != expect[i] {
+			t.Errorf("chain: expected %v, got %v", expect, chain)
+		}
+	}
+
+	hops := rl.Responses()
+	if len(hops) != 2 {
+		t.Fatalf("expected 2 hops, got %d", len(hops))
+	}
+	if hops[0].StatusCode != 301 || hops[0].Request.URL.String() != expect[0] {
+		t.Errorf("hop 0: got %d %s", hops[0].StatusCode, hops[0].Request.URL)
+	}
+	if hops[1].StatusCode != 302 || hops[1].Request.URL.String() != expect[1] {
+		t.Errorf("hop 1: got %d %s", hops[1].StatusCode, hops[1].Request.URL)
+	}
+	body, err := ioutil.ReadAll(hops[0].Body)
+	if err != nil || len(body) == 0 {
+		t.Errorf("hop 0: missing body (%v)", err)
+	}
+
+	// redirect loops should fail
+	resp, err = client.Get(srv.URL + "/loop")
+	if err == nil {
+		resp.Body.Close()
+		t.Errorf("redirect loop: expected error")
+	}
+}
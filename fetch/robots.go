@@ -0,0 +1,297 @@
+package fetch
+
+// robots.txt support.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDisallowed is returned when robots.txt forbids fetching a URL.
+var ErrDisallowed = errors.New("disallowed by robots.txt")
+
+// Robots holds the parsed contents of a robots.txt file.
+type Robots struct {
+	groups []*robotsGroup
+	// Sitemaps lists any "Sitemap:" entries
+	Sitemaps []string
+	// unavailable is set if the server had problems serving robots.txt,
+	// in which case nothing is allowed.
+	unavailable bool
+}
+
+type robotsGroup struct {
+	agents     []string // lowercased
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow bool
+	pat   string
+	re    *regexp.Regexp
+}
+
+// don't read in crazy-huge robots.txt files
+const maxRobotsSize = 512 * 1024
+
+// ParseRobots parses a robots.txt file.
+// It's lenient - unknown or malformed lines are ignored.
+func ParseRobots(in io.Reader) (*Robots, error) {
+	robots := &Robots{}
+	var group *robotsGroup
+	inAgents := false // currently reading a run of user-agent lines?
+
+	scanner := bufio.NewScanner(io.LimitReader(in, maxRobotsSize))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		field := strings.ToLower(strings.TrimSpace(parts[0]))
+		val := strings.TrimSpace(parts[1])
+
+		switch field {
+		case "user-agent":
+			if !inAgents {
+				group = &robotsGroup{}
+				robots.groups = append(robots.groups, group)
+				inAgents = true
+			}
+			group.agents = append(group.agents, strings.ToLower(val))
+			continue
+		case "allow", "disallow":
+			if group != nil && val != "" {
+				re, err := robotsPatToRegexp(val)
+				if err == nil {
+					group.rules = append(group.rules, robotsRule{allow: field == "allow", pat: val, re: re})
+				}
+			}
+		case "crawl-delay":
+			if group != nil {
+				secs, err := strconv.ParseFloat(val, 64)
+				if err == nil && secs > 0 {
+					group.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		case "sitemap":
+			// sitemap urls contain a colon, so use the whole of the original line
+			robots.Sitemaps = append(robots.Sitemaps, val)
+		}
+		inAgents = false
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return robots, nil
+}
+
+// convert a robots.txt path pattern (which can use '*' and '$') to a regexp
+func robotsPatToRegexp(pat string) (*regexp.Regexp, error) {
+	suffix := ""
+	if strings.HasSuffix(pat, "$") {
+		pat = pat[:len(pat)-1]
+		suffix = "$"
+	}
+	s := regexp.QuoteMeta(pat)
+	s = strings.Replace(s, `\*`, `.*`, -1)
+	return regexp.Compile("^" + s + suffix)
+}
+
+// find the group which applies to userAgent (nil if none)
+func (robots *Robots) group(userAgent string) *robotsGroup {
+	ua := strings.ToLower(userAgent)
+	var best *robotsGroup
+	bestLen := -1
+	for _, g := range robots.groups {
+		for _, agent := range g.agents {
+			if agent == "*" {
+				if bestLen < 0 {
+					best = g
+					bestLen = 0
+				}
+				continue
+			}
+			if strings.Contains(ua, agent) && len(agent) > bestLen {
+				best = g
+				bestLen = len(agent)
+			}
+		}
+	}
+	return best
+}
+
+// Allowed returns true if userAgent may fetch the given path (which
+// should include any query part, eg from url.URL.RequestURI()).
+// The longest matching rule wins, with allow winning ties.
+func (robots *Robots) Allowed(userAgent string, path string) bool {
+	if robots.unavailable {
+		return false
+	}
+	g := robots.group(userAgent)
+	if g == nil {
+		return true
+	}
+	allow := true
+	matchLen := -1
+	for _, rule := range g.rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if len(rule.pat) > matchLen || (len(rule.pat) == matchLen && rule.allow) {
+			allow = rule.allow
+			matchLen = len(rule.pat)
+		}
+	}
+	return allow
+}
+
+// CrawlDelay returns the Crawl-delay which applies to userAgent (0 if none)
+func (robots *Robots) CrawlDelay(userAgent string) time.Duration {
+	g := robots.group(userAgent)
+	if g == nil {
+		return 0
+	}
+	return g.crawlDelay
+}
+
+// Logger is the logging interface used by this package.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type robotsEntry struct {
+	ready   chan struct{} // closed once robots is set
+	robots  *Robots
+	expires time.Time
+}
+
+// how long to wait before retrying a robots.txt which couldn't be fetched
+const robotsRetryAge = 5 * time.Minute
+
+// RobotsCache fetches and caches robots.txt files, on a per-host basis.
+// It is safe to use from multiple goroutines.
+type RobotsCache struct {
+	Client    *http.Client
+	UserAgent string
+	// Polite, if set, has Crawl-delay values applied to it
+	Polite *PoliteTripper
+	// MaxAge is how long to cache robots.txt files for
+	MaxAge time.Duration
+	// ErrorLog, if set, is used to report problems fetching robots.txt
+	ErrorLog Logger
+
+	lock    sync.Mutex // protects entries (but isn't held while fetching)
+	entries map[string]*robotsEntry
+}
+
+func NewRobotsCache(client *http.Client, userAgent string) *RobotsCache {
+	return &RobotsCache{
+		Client:    client,
+		UserAgent: userAgent,
+		MaxAge:    24 * time.Hour,
+		entries:   make(map[string]*robotsEntry),
+	}
+}
+
+// Get returns the robots.txt rules for the host of u, fetching them if
+// they're not already cached.
+// If robots.txt is missing, or can't be fetched at all, an empty (allow
+// everything) set of rules is returned. If the server returns an error
+// (5xx), everything is disallowed for a few minutes, until it can be
+// retried.
+func (rc *RobotsCache) Get(u *url.URL) *Robots {
+	key := u.Scheme + "://" + u.Host
+	rc.lock.Lock()
+	entry, got := rc.entries[key]
+	if got {
+		select {
+		case <-entry.ready:
+			if time.Now().Before(entry.expires) {
+				rc.lock.Unlock()
+				return entry.robots
+			}
+			// stale - fetch a new one
+		default:
+			// another goroutine is already fetching it
+			rc.lock.Unlock()
+			<-entry.ready
+			return entry.robots
+		}
+	}
+	entry = &robotsEntry{ready: make(chan struct{})}
+	rc.entries[key] = entry
+	rc.lock.Unlock()
+
+	maxAge := rc.MaxAge
+	robots, err := rc.fetch(key + "/robots.txt")
+	if err != nil {
+		maxAge = robotsRetryAge
+		if robots == nil {
+			robots = &Robots{}
+		}
+		if rc.ErrorLog != nil {
+			if robots.unavailable {
+				rc.ErrorLog.Printf("robots.txt for %s: %s (disallowing everything for now)\n", u.Host, err)
+			} else {
+				rc.ErrorLog.Printf("robots.txt for %s: %s (assuming no restrictions)\n", u.Host, err)
+			}
+		}
+	}
+	rc.lock.Lock()
+	entry.robots = robots
+	entry.expires = time.Now().Add(maxAge)
+	close(entry.ready)
+	rc.lock.Unlock()
+
+	if delay := robots.CrawlDelay(rc.UserAgent); delay > 0 && rc.Polite != nil {
+		rc.Polite.SetHostDelay(u.Host, delay)
+	}
+	return robots
+}
+
+func (rc *RobotsCache) fetch(robotsURL string) (*Robots, error) {
+	req, err := http.NewRequest("GET", robotsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "*/*")
+	if rc.UserAgent != "" {
+		req.Header.Set("User-Agent", rc.UserAgent)
+	}
+	resp, err := rc.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		// no robots.txt => no restrictions
+		return &Robots{}, nil
+	}
+	if resp.StatusCode >= 500 {
+		// server trouble - don't assume anything is allowed
+		return &Robots{unavailable: true}, fmt.Errorf("HTTP code %d", resp.StatusCode)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP code %d", resp.StatusCode)
+	}
+	return ParseRobots(resp.Body)
+}
+
+// Allowed returns true if robots.txt permits fetching u.
+func (rc *RobotsCache) Allowed(u *url.URL) bool {
+	return rc.Get(u).Allowed(rc.UserAgent, u.RequestURI())
+}
@@ -0,0 +1,170 @@
+package fetch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRobots = `
+# comment
+User-agent: *
+Disallow: /search
+Disallow: /private/
+Allow: /private/public.html
+Disallow: /*.pdf$
+
+User-agent: BadBot
+User-agent: WorseBot
+Disallow: /
+
+User-agent: slowbot
+Crawl-delay: 2.5
+Disallow:
+
+Sitemap: https://example.com/sitemap.xml
+`
+
+func TestRobots(t *testing.T) {
+	robots, err := ParseRobots(strings.NewReader(testRobots))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []struct {
+		ua     string
+		path   string
+		expect bool
+	}{
+		{"Mozilla/5.0", "/", true},
+		{"Mozilla/5.0", "/news/foo.html", true},
+		{"Mozilla/5.0", "/search?q=wibble", false},
+		{"Mozilla/5.0", "/private/secret.html", false},
+		{"Mozilla/5.0", "/private/public.html", true},
+		{"Mozilla/5.0", "/docs/report.pdf", false},
+		{"Mozilla/5.0", "/docs/report.pdf?x=1", true},
+		{"Mozilla/5.0 (compatible; BadBot/1.0)", "/news/foo.html", false},
+		{"worsebot", "/", false},
+		{"SlowBot/2", "/search", true},
+		{"", "/search", false},
+	}
+	for _, dat := range testData {
+		got := robots.Allowed(dat.ua, dat.path)
+		if got != dat.expect {
+			t.Errorf("Allowed(%q,%q): expected %v, got %v", dat.ua, dat.path, dat.expect, got)
+		}
+	}
+
+	if d := robots.CrawlDelay("SlowBot"); d != 2500*time.Millisecond {
+		t.Errorf("CrawlDelay: expected 2.5s, got %s", d)
+	}
+	if d := robots.CrawlDelay("Mozilla/5.0"); d != 0 {
+		t.Errorf("CrawlDelay: expected 0, got %s", d)
+	}
+	if len(robots.Sitemaps) != 1 || robots.Sitemaps[0] != "https://example.com/sitemap.xml" {
+		t.Errorf("Sitemaps: got %v", robots.Sitemaps)
+	}
+}
+
+func TestRobotsCache(t *testing.T) {
+	fetchCnt := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fetchCnt++
+			w.Write([]byte("User-agent: *\nDisallow: /nope\nCrawl-delay: 3\n"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	polite := NewPoliteTripper()
+	rc := NewRobotsCache(&http.Client{}, "testbot")
+	rc.Polite = polite
+
+	for _, path := range []string{"/yep", "/nope/x", "/yep2"} {
+		u, _ := url.Parse(srv.URL + path)
+		got := rc.Allowed(u)
+		expect := !strings.HasPrefix(path, "/nope")
+		if got != expect {
+			t.Errorf("%s: expected %v, got %v", path, expect, got)
+		}
+	}
+	if fetchCnt != 1 {
+		t.Errorf("expected robots.txt to be fetched once, got %d", fetchCnt)
+	}
+	u, _ := url.Parse(srv.URL)
+	if d := polite.delayFor(u.Host); d != 3*time.Second {
+		t.Errorf("expected crawl delay of 3s, got %s", d)
+	}
+}
+
+func TestRobotsCacheServerError(t *testing.T) {
+	fetchCnt := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetchCnt++
+		http.Error(w, "oops", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	rc := NewRobotsCache(&http.Client{}, "testbot")
+	u, _ := url.Parse(srv.URL + "/yep")
+	if rc.Allowed(u) {
+		t.Errorf("expected 5xx robots.txt to disallow")
+	}
+	rc.Allowed(u)
+	if fetchCnt != 1 {
+		t.Errorf("expected robots.txt to be fetched once, got %d", fetchCnt)
+	}
+
+	// should be retried once the (short) cache period is up
+	rc.entries[srv.URL].expires = time.Now()
+	rc.Allowed(u)
+	if fetchCnt != 2 {
+		t.Errorf("expected robots.txt to be refetched, got %d fetches", fetchCnt)
+	}
+}
+
+func TestRobotsCacheSlowHost(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\nDisallow: /nope\n"))
+	}))
+	defer fast.Close()
+
+	rc := NewRobotsCache(&http.Client{}, "testbot")
+	slowURL, _ := url.Parse(slow.URL + "/yep")
+	fastURL, _ := url.Parse(fast.URL + "/nope")
+
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() { done <- rc.Allowed(slowURL) }()
+	}
+	// give the slow fetch a chance to start
+	time.Sleep(50 * time.Millisecond)
+
+	fastDone := make(chan bool)
+	go func() { fastDone <- rc.Allowed(fastURL) }()
+	select {
+	case allowed := <-fastDone:
+		if allowed {
+			t.Errorf("expected /nope to be disallowed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("fetch for one host blocked by another")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if !<-done {
+			t.Errorf("expected slow host to allow everything")
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HeaderTripper is a http.RoundTripper which sets extra headers on every
+// request, overriding any already set.
+type HeaderTripper struct {
+	Header http.Header
+	// Transport performs the actual requests (nil means http.DefaultTransport)
+	Transport http.RoundTripper
+}
+
+func (ht *HeaderTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := ht.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if len(ht.Header) > 0 {
+		// RoundTrippers shouldn't modify the request
+		req = req.Clone(req.Context())
+		for name, vals := range ht.Header {
+			req.Header[name] = vals
+		}
+	}
+	return transport.RoundTrip(req)
+}
+
+// TimeoutTripper is a http.RoundTripper which limits the time a request
+// can take, including reading the response body.
+type TimeoutTripper struct {
+	Timeout time.Duration
+	// Transport performs the actual requests (nil means http.DefaultTransport)
+	Transport http.RoundTripper
+}
+
+func (tt *TimeoutTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := tt.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if tt.Timeout <= 0 {
+		return transport.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), tt.Timeout)
+	resp, err := transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the request context when the body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
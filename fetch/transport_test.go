@@ -0,0 +1,59 @@
+package fetch
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte(r.Header.Get("Accept-Language") + "|" + r.Header.Get("Accept")))
+	}))
+	defer srv.Close()
+
+	hdr := http.Header{}
+	hdr.Set("Accept-Language", "es-MX")
+	hdr.Set("Accept", "text/html")
+	client := &http.Client{
+		Transport: &HeaderTripper{
+			Header:    hdr,
+			Transport: &TimeoutTripper{Timeout: 100 * time.Millisecond},
+		},
+	}
+
+	req, err := http.NewRequest("GET", srv.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "*/*")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "es-MX|text/html" {
+		t.Errorf("headers not applied (got %q)", body)
+	}
+	if req.Header.Get("Accept") != "*/*" {
+		t.Errorf("original request modified")
+	}
+
+	resp, err = client.Get(srv.URL + "/slow")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected timeout")
+	}
+	if !IsTransientError(err) {
+		t.Errorf("timeout should be transient (got %s)", err)
+	}
+}
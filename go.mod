@@ -7,6 +7,7 @@ require (
 	github.com/andybalholm/cascadia v1.3.1
 	github.com/bcampbell/arts v0.0.0-20180814213625-e72d39b0a374
 	github.com/bcampbell/biscuit v0.0.0-20170610214738-c44fbed3888c
+	github.com/bcampbell/fuzzytime v0.0.0-20191010161914-05ea0010feac
 	github.com/bcampbell/htmlutil v0.0.0-20160926021243-b3c26999cab1
 	github.com/bcampbell/warc v0.0.0-20210206221533-eb7282a18f07
 	github.com/elazarl/go-bindata-assetfs v1.0.1
@@ -21,7 +22,6 @@ require (
 
 require (
 	github.com/PuerkitoBio/urlesc v0.0.0-20170810143723-de5bf2ad4578 // indirect
-	github.com/bcampbell/fuzzytime v0.0.0-20191010161914-05ea0010feac // indirect
 	github.com/felixge/httpsnoop v1.0.1 // indirect
 	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
 	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211 // indirect
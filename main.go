@@ -10,15 +10,19 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"path"
 	"path/filepath"
 	"sort"
 	"strings"
-	"sync"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/bcampbell/scrapeomat/discover"
+	"github.com/bcampbell/scrapeomat/store"
 	"github.com/bcampbell/scrapeomat/store/sqlstore"
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
@@ -29,10 +33,22 @@ var opts struct {
 	verbosity         int
 	scraperConfigPath string
 	archivePath       string
+	stateDir          string
+	archiveFirst      bool
+	archiveMaxAge     string
 	inputFile         string
 	updateMode        bool
+	fallback          string
 	discover          bool
+	reportFile        string
+	dryRun            bool
+	explainURL        string
+	outFile           string
 	list              bool
+	check             bool
+	history           int
+	metricsAddr       string
+	controlAddr       string
 	driver            string // database driver
 	db                string // db connection string
 }
@@ -58,16 +74,50 @@ options:
 	}
 	flag.IntVar(&opts.verbosity, "v", 1, "verbosity of output (0=errors only 1=info 2=debug)")
 	flag.StringVar(&opts.scraperConfigPath, "s", "scrapers", "path for scraper configs")
-	flag.StringVar(&opts.archivePath, "a", "archive", "archive dir to dump .warc files into")
+	flag.StringVar(&opts.archivePath, "a", "archive", "archive dir to dump .warc files into (empty to disable archiving)")
+	flag.StringVar(&opts.stateDir, "statedir", "state", "`dir` for state kept between runs (eg to skip unchanged nav pages). Empty to disable.")
+	flag.BoolVar(&opts.archiveFirst, "archivefirst", false, "use articles already in the archive dir instead of fetching them again")
+	flag.StringVar(&opts.archiveMaxAge, "archivemaxage", "", "max `age` of archived articles to use with -archivefirst (eg \"720h\", default no limit)")
 	flag.BoolVar(&opts.list, "l", false, "List target sites and exit")
+	flag.BoolVar(&opts.check, "check", false, "check scraper config files for problems, then exit (non-zero exit status if any found)")
 	flag.BoolVar(&opts.discover, "discover", false, "run discovery for target sites, output article links to stdout, then exit")
+	flag.StringVar(&opts.reportFile, "report", "", "with -discover, write a report of the pages visited and links found to `file` (HTML if it ends in .html, else JSON)")
+	flag.BoolVar(&opts.dryRun, "dryrun", false, "run target sites once (or the -i list), writing articles as JSON lines instead of to the db, then exit")
+	flag.StringVar(&opts.outFile, "o", "-", "output `file` for -dryrun (\"-\" for stdout)")
+	flag.StringVar(&opts.explainURL, "explain", "", "scrape a single article `URL`, showing each step in detail (nothing is stored), then exit")
+	flag.IntVar(&opts.history, "history", 0, "show the last `N` recorded runs for each target site (all sites if none given), then exit")
 	flag.StringVar(&opts.inputFile, "i", "", "input file of URLs (runs scrapers then exit)")
 	flag.BoolVar(&opts.updateMode, "update", false, "Update articles already in db (when using -i)")
+	flag.StringVar(&opts.fallback, "fallback", "", "`scraper` to handle any URLs which no target site accepts (when using -i)")
+	flag.StringVar(&opts.metricsAddr, "metrics", "", "serve Prometheus metrics over HTTP on `addr` (eg \":9100\") when running continuously")
+	flag.StringVar(&opts.controlAddr, "control", "", "serve the control API on `addr` (eg \"localhost:9101\" or \"unix:/tmp/scrapeomat.sock\") when running continuously")
 	flag.StringVar(&opts.driver, "driver", "", "database driver (overrides SCRAPEOMAT_DRIVER)")
 	flag.StringVar(&opts.db, "db", "", "database connection string (overrides SCRAPEOMAT_DB)")
 	flag.Parse()
 
-	scrapers, err := buildScrapers()
+	if opts.check {
+		problemCnt, err := checkConfigs(opts.scraperConfigPath, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		if problemCnt > 0 {
+			fmt.Fprintf(os.Stderr, "%d problems found\n", problemCnt)
+			os.Exit(1)
+		}
+		return
+	}
+
+	confs, err := loadScraperConfigs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	if opts.dryRun {
+		// don't touch the nav page caches
+		opts.stateDir = ""
+	}
+	scrapers, err := buildScrapers(confs)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
 		os.Exit(1)
@@ -77,12 +127,16 @@ options:
 		fmt.Fprintf(os.Stderr, "ERROR: -update can only be used with -i\n")
 		os.Exit(1)
 	}
+	if opts.fallback != "" && opts.inputFile == "" {
+		fmt.Fprintf(os.Stderr, "ERROR: -fallback can only be used with -i\n")
+		os.Exit(1)
+	}
 
 	if opts.list {
 		// just list available scrapers and exit
 		names := sort.StringSlice{}
 		for _, scraper := range scrapers {
-			names = append(names, scraper.Name)
+			names = append(names, scraper.Name())
 		}
 		sort.Sort(names)
 		for _, name := range names {
@@ -94,7 +148,8 @@ options:
 
 	// which sites?
 	targetSites := flag.Args()
-	if len(targetSites) == 1 && targetSites[0] == "ALL" {
+	allSites := len(targetSites) == 1 && targetSites[0] == "ALL"
+	if allSites {
 		// do the lot
 		targetSites = []string{}
 		for siteName, _ := range scrapers {
@@ -103,7 +158,7 @@ options:
 	}
 
 	// resolve names to scrapers
-	targetScrapers := make([]*Scraper, 0, len(targetSites))
+	targetScrapers := make([]Scraper, 0, len(targetSites))
 	for _, siteName := range targetSites {
 		scraper, got := scrapers[siteName]
 		if !got {
@@ -113,19 +168,72 @@ options:
 		targetScrapers = append(targetScrapers, scraper)
 	}
 
+	if opts.reportFile != "" && !opts.discover {
+		fmt.Fprintf(os.Stderr, "ERROR: -report can only be used with -discover\n")
+		os.Exit(1)
+	}
+
 	if opts.discover {
 		// just run discovery phase, print out article URLs, then exit
+		reports := []*discover.Report{}
 		for _, scraper := range targetScrapers {
 			err := scraper.Login()
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%s\n", err)
 				continue
 			}
-			foundArts, _ := scraper.Discover()
+			var foundArts []string
+			if r, ok := scraper.(DiscoveryReporter); ok && opts.reportFile != "" {
+				var report *discover.Report
+				foundArts, report, _ = r.DiscoverReport()
+				reports = append(reports, report)
+			} else {
+				foundArts, _ = scraper.Discover()
+			}
 			for _, a := range foundArts {
 				fmt.Println(a)
 			}
 		}
+		if opts.reportFile != "" {
+			err = writeReport(opts.reportFile, reports)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	if opts.explainURL != "" {
+		// the db is only used to check for existing copies
+		var db store.Store
+		ss, err := openExistingDB(opts.driver, opts.db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: not checking db (%s)\n", err)
+		} else if ss != nil {
+			db = ss
+		}
+		err = explain(opts.explainURL, targetScrapers, scrapers, db, os.Stdout)
+		if ss != nil {
+			ss.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.dryRun {
+		if opts.history > 0 {
+			fmt.Fprintf(os.Stderr, "ERROR: -history can't be used with -dryrun\n")
+			os.Exit(1)
+		}
+		err = dryRun(targetScrapers, scrapers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
 		return
 	}
 
@@ -136,51 +244,58 @@ options:
 	}
 	defer db.Close()
 
-	// running with input file?
-	if opts.inputFile != "" {
-		// read in the input URLs from file
-
-		var err error
-		artURLs := []string{}
-
-		inFile, err := os.Open(opts.inputFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR opening input list: %s\n", err)
-			os.Exit(1)
-		}
-		scanner := bufio.NewScanner(inFile)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line != "" {
-				artURLs = append(artURLs, line)
+	if opts.history > 0 {
+		names := targetSites
+		if len(names) == 0 {
+			for name, _ := range scrapers {
+				names = append(names, name)
 			}
 		}
-		if err = scanner.Err(); err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR reading %s: %s\n", opts.inputFile, err)
+		sort.Strings(names)
+		err = showHistory(db, names, opts.history)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
 			os.Exit(1)
 		}
-		if len(targetScrapers) != 1 {
-			fmt.Fprintf(os.Stderr, "Only one scraper allowed with -i flag\n")
-			// TODO: use scraper host and article patterns to pick a scraper?
-			// hardly even need a scraper anyway - the article scraping part is mostly
-			// generic...
-			// scraper-specific stuff: pubcode, article accept/reject rules, paywall handling... custom stuff (eg json-based articles)
+		return
+	}
+
+	// running with input file?
+	if opts.inputFile != "" {
+		artURLs, err := readURLList(opts.inputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
 			os.Exit(1)
 		}
-
-		// invoke scraper
-		for _, scraper := range targetScrapers {
-			err = scraper.DoRunFromList(artURLs, db, opts.updateMode)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
-				os.Exit(1)
-			}
+		err = runFromList(artURLs, targetScrapers, scrapers, db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
 		}
 		return
 	}
 
 	// Run as a server
 
+	if opts.metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		go func() {
+			err := http.ListenAndServe(opts.metricsAddr, mux)
+			fmt.Fprintf(os.Stderr, "ERROR: metrics server: %s\n", err)
+		}()
+	}
+
+	d := newDaemon(db)
+
+	if opts.controlAddr != "" {
+		err = listenControl(opts.controlAddr, d)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: control API: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
 	sigChan := make(chan os.Signal, 1)
 
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
@@ -189,26 +304,33 @@ options:
 		s := <-sigChan
 		fmt.Fprintf(os.Stderr, "Signal received (%s). Stopping scrapers...\n", s)
 		// stop all the scrapers
-		for _, scraper := range targetScrapers {
-			scraper.Stop()
+		d.stopAll()
+	}()
+
+	// reload configs upon SIGHUP
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			fmt.Fprintf(os.Stderr, "SIGHUP received. Reloading scraper configs...\n")
+			err := reloadScrapers(d, targetSites, allSites)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: reload failed (keeping old configs): %s\n", err)
+			}
 		}
 	}()
 
-	var wg sync.WaitGroup
 	for _, scraper := range targetScrapers {
-		wg.Add(1)
-		go func(s *Scraper) {
-			defer wg.Done()
-			s.Start(db)
-		}(scraper)
+		d.start(scraper, confs[scraper.Name()])
 	}
 
-	wg.Wait()
+	d.wait()
 	fmt.Println("Shutdown complete. Exiting.")
 }
 
-func buildScrapers() (map[string]*Scraper, error) {
-	// scraper configuration
+// loadScraperConfigs reads in all the scraper config files, and applies any
+// per-run overrides from the command line.
+func loadScraperConfigs() (map[string]*ScraperConf, error) {
 	scrapersCfg := struct {
 		Scraper map[string]*ScraperConf
 	}{}
@@ -228,10 +350,27 @@ func buildScrapers() (map[string]*Scraper, error) {
 		}
 	}
 
-	// build scrapers from configuration entries
-	scrapers := make(map[string]*Scraper)
-	for name, conf := range scrapersCfg.Scraper {
-		scraper, err := NewScraper(name, conf, opts.verbosity, opts.archivePath)
+	for _, conf := range scrapersCfg.Scraper {
+		// apply per-run overrides
+		if opts.archiveFirst {
+			conf.ArchiveFirst = true
+		}
+		if opts.archiveMaxAge != "" {
+			conf.ArchiveMaxAge = opts.archiveMaxAge
+		}
+	}
+	return scrapersCfg.Scraper, nil
+}
+
+func buildScraper(name string, conf *ScraperConf) (Scraper, error) {
+	return NewScraper(name, conf, opts.verbosity, opts.archivePath, opts.stateDir)
+}
+
+// build scrapers from configuration entries
+func buildScrapers(confs map[string]*ScraperConf) (map[string]Scraper, error) {
+	scrapers := make(map[string]Scraper)
+	for name, conf := range confs {
+		scraper, err := buildScraper(name, conf)
 		if err != nil {
 			return nil, err
 		}
@@ -239,3 +378,170 @@ func buildScrapers() (map[string]*Scraper, error) {
 	}
 	return scrapers, nil
 }
+
+// reloadScrapers rereads the config files and updates the running
+// scrapers to match.
+// If the scrapers weren't given as "ALL", only the ones originally named
+// on the command line are considered.
+func reloadScrapers(d *daemon, targetSites []string, allSites bool) error {
+	confs, err := loadScraperConfigs()
+	if err != nil {
+		return err
+	}
+	if !allSites {
+		wanted := map[string]*ScraperConf{}
+		for _, name := range targetSites {
+			if conf, got := confs[name]; got {
+				wanted[name] = conf
+			}
+		}
+		confs = wanted
+	}
+	d.reload(confs, buildScraper)
+	return nil
+}
+
+// showHistory dumps out the most recent runs for the named scrapers.
+func showHistory(db store.Store, names []string, count int) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "SCRAPER\tSTARTED\tDURATION\tPAGES\tPAGEERRS\tFOUND\tNEW\tSTASHED\tERRS\tABORTED\n")
+	for _, name := range names {
+		runs, err := db.FetchRuns(name, count)
+		if err != nil {
+			return err
+		}
+		for _, run := range runs {
+			duration := "-"
+			if !run.Finished.IsZero() {
+				duration = run.Finished.Sub(run.Started).Truncate(time.Second).String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n",
+				run.Scraper,
+				run.Started.Local().Format("2006-01-02 15:04:05"),
+				duration,
+				run.PagesFetched,
+				run.PageErrors,
+				run.ArtsFound,
+				run.ArtsNew,
+				run.ArtsStashed,
+				run.ArtErrors,
+				run.AbortReason)
+		}
+	}
+	return w.Flush()
+}
+
+// writeReport writes out discovery reports, as HTML if the filename
+// ends in .html (or .htm), otherwise JSON.
+func writeReport(fileName string, reports []*discover.Report) error {
+	outFile, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".html", ".htm":
+		err = discover.WriteReportsHTML(outFile, reports)
+	default:
+		err = discover.WriteReportsJSON(outFile, reports)
+	}
+	if err != nil {
+		outFile.Close()
+		return err
+	}
+	return outFile.Close()
+}
+
+// readURLList reads in a file of URLs, one per line.
+func readURLList(fileName string) ([]string, error) {
+	artURLs := []string{}
+
+	inFile, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("opening input list: %s", err)
+	}
+	defer inFile.Close()
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			artURLs = append(artURLs, line)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %s", fileName, err)
+	}
+	return artURLs, nil
+}
+
+// runFromList scrapes a list of article URLs.
+// If a single target scraper is given, it gets all the URLs. Otherwise
+// each URL is routed to whichever target scraper accepts it, with any
+// unmatched URLs going to the -fallback scraper (if set).
+func runFromList(artURLs []string, targetScrapers []Scraper, allScrapers map[string]Scraper, db store.Store) error {
+	if len(targetScrapers) == 0 {
+		return fmt.Errorf("no scrapers specified")
+	}
+
+	var fallback FallbackScraper
+	fallbackName := ""
+	if opts.fallback != "" {
+		scraper, got := allScrapers[opts.fallback]
+		if !got {
+			return fmt.Errorf("unknown fallback scraper '%s'", opts.fallback)
+		}
+		fallback, got = scraper.(FallbackScraper)
+		if !got {
+			return fmt.Errorf("scraper '%s' can't be used as a fallback", opts.fallback)
+		}
+		fallbackName = scraper.Name()
+	}
+
+	if len(targetScrapers) == 1 && fallback == nil {
+		// the simple case
+		return targetScrapers[0].DoRunFromList(artURLs, db, opts.updateMode)
+	}
+
+	groups, unmatched := routeURLs(artURLs, targetScrapers)
+	if len(unmatched) > 0 {
+		for _, u := range unmatched {
+			fmt.Fprintf(os.Stderr, "Unmatched: %s\n", u)
+		}
+		if fallback != nil {
+			fmt.Fprintf(os.Stderr, "%d unmatched URLs (passing to %s)\n", len(unmatched), fallbackName)
+			groups[fallbackName] = append(groups[fallbackName], unmatched...)
+		} else {
+			fmt.Fprintf(os.Stderr, "%d unmatched URLs (skipped)\n", len(unmatched))
+		}
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failCnt := 0
+	for _, name := range names {
+		scraper, got := allScrapers[name]
+		if !got {
+			// shouldn't happen
+			return fmt.Errorf("unknown scraper '%s'", name)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %d URLs\n", name, len(groups[name]))
+		var err error
+		if name == fallbackName {
+			// don't let the fallback's own URL rules reject everything
+			err = fallback.DoRunFromListUnchecked(groups[name], db, opts.updateMode)
+		} else {
+			err = scraper.DoRunFromList(groups[name], db, opts.updateMode)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR (%s): %s\n", name, err)
+			failCnt++
+		}
+	}
+	if failCnt > 0 {
+		return fmt.Errorf("%d of %d scrapers failed", failCnt, len(names))
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+// Per-scraper metrics, served up in the Prometheus text exposition format
+// (see the -metrics flag).
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ScraperMetrics holds the metrics for a single scraper.
+type ScraperMetrics struct {
+	LastRunStart time.Time
+	LastRunEnd   time.Time
+	// LastRunOK is set if the last run completed without being aborted
+	LastRunOK      bool
+	LastRunStashed int
+	NextRun        time.Time
+
+	// running totals, since startup
+	Runs            int
+	Stashed         int
+	ArticleErrors   int
+	DiscoveryErrors int
+	PagesFetched    int
+	// HTTP responses, by status code ("error" for transport failures)
+	Responses map[string]int
+}
+
+// Metrics collects the metrics for all the scrapers.
+// It's safe to use from multiple goroutines.
+type Metrics struct {
+	lock     sync.Mutex
+	scrapers map[string]*ScraperMetrics
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{scrapers: map[string]*ScraperMetrics{}}
+}
+
+// the global metrics, updated by all the scrapers
+var metrics = NewMetrics()
+
+// get the entry for a scraper, creating it if needed (lock must be held)
+func (m *Metrics) get(name string) *ScraperMetrics {
+	sm, got := m.scrapers[name]
+	if !got {
+		sm = &ScraperMetrics{Responses: map[string]int{}}
+		m.scrapers[name] = sm
+	}
+	return sm
+}
+
+// RunStarted records the start of a run.
+func (m *Metrics) RunStarted(name string, t time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.get(name).LastRunStart = t
+}
+
+// RunFinished records the outcome of a run.
+func (m *Metrics) RunFinished(name string, stats *ScrapeStats, ok bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	sm := m.get(name)
+	sm.LastRunStart = stats.Start
+	sm.LastRunEnd = stats.End
+	sm.LastRunOK = ok
+	sm.LastRunStashed = stats.StashCount
+	sm.Runs++
+	sm.Stashed += stats.StashCount
+	sm.ArticleErrors += stats.ErrorCount
+	sm.DiscoveryErrors += stats.Discovery.ErrorCount
+	sm.PagesFetched += stats.Discovery.FetchCount
+}
+
+// Get returns a copy of the metrics for a scraper (minus the response
+// counts).
+func (m *Metrics) Get(name string) (ScraperMetrics, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	sm, got := m.scrapers[name]
+	if !got {
+		return ScraperMetrics{}, false
+	}
+	out := *sm
+	out.Responses = nil
+	return out, true
+}
+
+// SetNextRun records when the next run is scheduled.
+func (m *Metrics) SetNextRun(name string, t time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.get(name).NextRun = t
+}
+
+// CountResponse records a HTTP response (or failure, if code is 0).
+func (m *Metrics) CountResponse(name string, code int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	label := "error"
+	if code != 0 {
+		label = strconv.Itoa(code)
+	}
+	m.get(name).Responses[label]++
+}
+
+// unix timestamp for gauges (0 for unset times)
+func timestamp(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+// Write outputs all the metrics in Prometheus text format.
+func (m *Metrics) Write(w io.Writer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	names := make([]string, 0, len(m.scrapers))
+	for name, _ := range m.scrapers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type metric struct {
+		name, typ, help string
+		val             func(sm *ScraperMetrics) float64
+	}
+	simple := []metric{
+		{"scrapeomat_last_run_start_timestamp_seconds", "gauge", "Start time of the last run.",
+			func(sm *ScraperMetrics) float64 { return timestamp(sm.LastRunStart) }},
+		{"scrapeomat_last_run_end_timestamp_seconds", "gauge", "End time of the last run.",
+			func(sm *ScraperMetrics) float64 { return timestamp(sm.LastRunEnd) }},
+		{"scrapeomat_last_run_success", "gauge", "1 if the last run completed without being aborted.",
+			func(sm *ScraperMetrics) float64 {
+				if sm.LastRunOK {
+					return 1
+				}
+				return 0
+			}},
+		{"scrapeomat_last_run_articles_stashed", "gauge", "Articles stored by the last run.",
+			func(sm *ScraperMetrics) float64 { return float64(sm.LastRunStashed) }},
+		{"scrapeomat_next_run_timestamp_seconds", "gauge", "Time of the next scheduled run.",
+			func(sm *ScraperMetrics) float64 { return timestamp(sm.NextRun) }},
+		{"scrapeomat_runs_total", "counter", "Runs completed.",
+			func(sm *ScraperMetrics) float64 { return float64(sm.Runs) }},
+		{"scrapeomat_articles_stashed_total", "counter", "Articles stored.",
+			func(sm *ScraperMetrics) float64 { return float64(sm.Stashed) }},
+		{"scrapeomat_discovery_pages_fetched_total", "counter", "Nav pages fetched during discovery.",
+			func(sm *ScraperMetrics) float64 { return float64(sm.PagesFetched) }},
+	}
+
+	for _, met := range simple {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", met.name, met.help, met.name, met.typ); err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{scraper=%q} %s\n", met.name, name, formatFloat(met.val(m.scrapers[name])))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP scrapeomat_fetch_errors_total Failed fetches, by phase.\n# TYPE scrapeomat_fetch_errors_total counter\n")
+	for _, name := range names {
+		sm := m.scrapers[name]
+		fmt.Fprintf(w, "scrapeomat_fetch_errors_total{scraper=%q,phase=\"discovery\"} %d\n", name, sm.DiscoveryErrors)
+		fmt.Fprintf(w, "scrapeomat_fetch_errors_total{scraper=%q,phase=\"article\"} %d\n", name, sm.ArticleErrors)
+	}
+
+	fmt.Fprintf(w, "# HELP scrapeomat_http_responses_total HTTP responses, by status code.\n# TYPE scrapeomat_http_responses_total counter\n")
+	for _, name := range names {
+		sm := m.scrapers[name]
+		codes := make([]string, 0, len(sm.Responses))
+		for code, _ := range sm.Responses {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "scrapeomat_http_responses_total{scraper=%q,code=%q} %d\n", name, code, sm.Responses[code])
+		}
+	}
+	return nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// ServeHTTP serves up the metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.Write(w)
+}
+
+// countingTripper is a http.RoundTripper which counts responses by status
+// code.
+type countingTripper struct {
+	name string
+	next http.RoundTripper
+}
+
+func (ct *countingTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := ct.next.RoundTrip(req)
+	if err != nil {
+		metrics.CountResponse(ct.name, 0)
+	} else {
+		metrics.CountResponse(ct.name, resp.StatusCode)
+	}
+	return resp, err
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	start := time.Unix(1600000000, 0)
+	stats := &ScrapeStats{Start: start, End: start.Add(90 * time.Second), StashCount: 12, ErrorCount: 2}
+	stats.Discovery.FetchCount = 5
+	m.RunStarted("foo", start)
+	m.RunFinished("foo", stats, true)
+	m.RunFinished("foo", stats, false)
+	m.CountResponse("foo", 200)
+	m.CountResponse("foo", 200)
+	m.CountResponse("foo", 0)
+	m.SetNextRun("bar", start.Add(time.Hour))
+
+	var buf bytes.Buffer
+	err := m.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, expect := range []string{
+		`scrapeomat_last_run_start_timestamp_seconds{scraper="foo"} 1600000000`,
+		`scrapeomat_last_run_end_timestamp_seconds{scraper="foo"} 1600000090`,
+		`scrapeomat_last_run_success{scraper="foo"} 0`,
+		`scrapeomat_articles_stashed_total{scraper="foo"} 24`,
+		`scrapeomat_discovery_pages_fetched_total{scraper="foo"} 10`,
+		`scrapeomat_fetch_errors_total{scraper="foo",phase="article"} 4`,
+		`scrapeomat_http_responses_total{scraper="foo",code="200"} 2`,
+		`scrapeomat_http_responses_total{scraper="foo",code="error"} 1`,
+		`scrapeomat_next_run_timestamp_seconds{scraper="bar"} 1600003600`,
+		`scrapeomat_last_run_start_timestamp_seconds{scraper="bar"} 0`,
+	} {
+		if !strings.Contains(out, expect+"\n") {
+			t.Errorf("missing %q", expect)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ScraperFactory creates a scraper from a config entry.
+// archiveDir is where raw responses are archived, stateDir is for any
+// state the scraper wants to keep between runs ("" = none).
+type ScraperFactory func(name string, conf *ScraperConf, verbosity int, archiveDir string, stateDir string) (Scraper, error)
+
+// the scraper implementations available, keyed by type name
+var scraperTypes = map[string]ScraperFactory{}
+
+// default type, for configs with no "type" key
+const defaultScraperType = "generic"
+
+// RegisterScraperType makes a scraper implementation available for use
+// via the "type" key in scraper config files, eg:
+//
+//	[scraper "foo"]
+//	type="foo-custom"
+//
+// Custom scrapers should call this from an init() function.
+func RegisterScraperType(typ string, factory ScraperFactory) {
+	if _, got := scraperTypes[typ]; got {
+		panic(fmt.Sprintf("scraper type '%s' registered twice", typ))
+	}
+	scraperTypes[typ] = factory
+}
+
+// ScraperTypes returns the names of all the registered scraper types.
+func ScraperTypes() []string {
+	types := make([]string, 0, len(scraperTypes))
+	for typ, _ := range scraperTypes {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// NewScraper creates a scraper of the type given in the config.
+func NewScraper(name string, conf *ScraperConf, verbosity int, archiveDir string, stateDir string) (Scraper, error) {
+	typ := conf.Type
+	if typ == "" {
+		typ = defaultScraperType
+	}
+	factory, got := scraperTypes[typ]
+	if !got {
+		return nil, fmt.Errorf("%s: unknown scraper type '%s' (known types: %v)", name, typ, ScraperTypes())
+	}
+	return factory(name, conf, verbosity, archiveDir, stateDir)
+}
+
+func init() {
+	RegisterScraperType(defaultScraperType, func(name string, conf *ScraperConf, verbosity int, archiveDir string, stateDir string) (Scraper, error) {
+		scraper, err := NewGenericScraper(name, conf, verbosity, archiveDir, stateDir)
+		if err != nil {
+			return nil, err
+		}
+		return scraper, nil
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bcampbell/scrapeomat/store"
+	"gopkg.in/gcfg.v1"
+)
+
+// fakeScraper is a do-nothing Scraper, for testing. Start() just waits
+// for Stop().
+type fakeScraper struct {
+	name     string
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+func newFakeScraper(name string) *fakeScraper {
+	return &fakeScraper{name: name, quit: make(chan struct{})}
+}
+
+func (s *fakeScraper) Name() string                { return s.name }
+func (s *fakeScraper) Login() error                { return nil }
+func (s *fakeScraper) Discover() ([]string, error) { return []string{}, nil }
+func (s *fakeScraper) DoRun(db store.Store) error  { return nil }
+func (s *fakeScraper) DoRunFromList(arts []string, db store.Store, updateMode bool) error {
+	return nil
+}
+func (s *fakeScraper) Start(db store.Store) { <-s.quit }
+func (s *fakeScraper) Stop()                { s.quitOnce.Do(func() { close(s.quit) }) }
+
+func TestNewScraper(t *testing.T) {
+	// use a private registry, so nothing leaks into other tests
+	saved := scraperTypes
+	defer func() { scraperTypes = saved }()
+	scraperTypes = map[string]ScraperFactory{}
+	for typ, factory := range saved {
+		scraperTypes[typ] = factory
+	}
+	RegisterScraperType("test-custom", func(name string, conf *ScraperConf, verbosity int, archiveDir string, stateDir string) (Scraper, error) {
+		return newFakeScraper(name), nil
+	})
+
+	cfg := struct {
+		Scraper map[string]*ScraperConf
+	}{}
+	err := gcfg.ReadStringInto(&cfg, `[scraper "plain"]
+url="http://example.com"
+
+[scraper "custom"]
+type="test-custom"
+url="http://example.com"
+
+[scraper "bad"]
+type="wibble"
+url="http://example.com"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no type => generic
+	s, err := NewScraper("plain", cfg.Scraper["plain"], 0, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*GenericScraper); !ok {
+		t.Errorf("default type: expected *GenericScraper, got %T", s)
+	}
+
+	s, err = NewScraper("custom", cfg.Scraper["custom"], 0, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs, ok := s.(*fakeScraper); !ok || fs.Name() != "custom" {
+		t.Errorf("custom type: expected *fakeScraper 'custom', got %T", s)
+	}
+
+	_, err = NewScraper("bad", cfg.Scraper["bad"], 0, "", "")
+	if err == nil {
+		t.Fatalf("unknown type: expected error")
+	}
+	if !strings.Contains(err.Error(), "wibble") || !strings.Contains(err.Error(), "test-custom") {
+		t.Errorf("unknown type: unhelpful error: %s", err)
+	}
+
+	// registering twice should panic
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic on duplicate registration")
+		}
+	}()
+	RegisterScraperType("test-custom", nil)
+}
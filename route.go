@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/bcampbell/scrapeomat/store"
+)
+
+// URLMatcher is implemented by scrapers which can say whether an article
+// URL belongs to them. It's used to route lists of URLs (eg from -i) to
+// the right scrapers.
+type URLMatcher interface {
+	// CookArticleURL applies the scraper's URL rules (host, article
+	// patterns, query stripping etc) to an article URL. It returns the
+	// normalised URL, or an error if the scraper rejects it.
+	CookArticleURL(artURL string) (string, error)
+}
+
+// FallbackScraper is implemented by scrapers which can take article URLs
+// that fail their own URL rules. It's needed for the -fallback scraper,
+// which gets the URLs no other scraper would accept.
+type FallbackScraper interface {
+	// DoRunFromListUnchecked is like DoRunFromList, but doesn't apply the
+	// scraper's URL rules.
+	DoRunFromListUnchecked(arts []string, db store.Store, updateMode bool) error
+}
+
+// routeURLs assigns each article URL to the first scraper (by name) which
+// accepts it.
+// Returns the (normalised) URLs grouped by scraper name, and a list of the
+// URLs no scraper would accept.
+func routeURLs(artURLs []string, scrapers []Scraper) (map[string][]string, []string) {
+	// check scrapers in a consistent order
+	sorted := make([]Scraper, len(scrapers))
+	copy(sorted, scrapers)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name() < sorted[j].Name() })
+
+	groups := map[string][]string{}
+	unmatched := []string{}
+	for _, artURL := range artURLs {
+		matched := false
+		for _, scraper := range sorted {
+			m, ok := scraper.(URLMatcher)
+			if !ok {
+				continue
+			}
+			cooked, err := m.CookArticleURL(artURL)
+			if err != nil {
+				continue
+			}
+			groups[scraper.Name()] = append(groups[scraper.Name()], cooked)
+			matched = true
+			break
+		}
+		if !matched {
+			unmatched = append(unmatched, artURL)
+		}
+	}
+	return groups, unmatched
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bcampbell/scrapeomat/store"
+)
+
+// routeScraper is a fake scraper which accepts URLs starting with any of
+// its prefixes (minus any query string), and records the URLs it's asked
+// to scrape.
+type routeScraper struct {
+	*fakeScraper
+	prefixes  []string
+	got       []string
+	unchecked []string
+}
+
+func newRouteScraper(name string, prefixes ...string) *routeScraper {
+	return &routeScraper{fakeScraper: newFakeScraper(name), prefixes: prefixes}
+}
+
+func (s *routeScraper) CookArticleURL(artURL string) (string, error) {
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(artURL, prefix) {
+			return strings.SplitN(artURL, "?", 2)[0], nil
+		}
+	}
+	return "", fmt.Errorf("no match")
+}
+
+func (s *routeScraper) DoRunFromList(arts []string, db store.Store, updateMode bool) error {
+	s.got = append(s.got, arts...)
+	return nil
+}
+
+func (s *routeScraper) DoRunFromListUnchecked(arts []string, db store.Store, updateMode bool) error {
+	s.unchecked = append(s.unchecked, arts...)
+	return nil
+}
+
+func TestRouteURLs(t *testing.T) {
+	// out of order, to check the first scraper by name wins
+	scrapers := []Scraper{
+		newRouteScraper("c", "http://c.com/"),
+		newRouteScraper("b", "http://a.com/news/"),
+		newRouteScraper("a", "http://a.com/"),
+		newFakeScraper("plain"), // not a URLMatcher
+	}
+
+	testData := []struct {
+		urls            []string
+		expectGroups    map[string][]string
+		expectUnmatched []string
+	}{
+		// overlapping patterns
+		{[]string{"http://a.com/news/1"}, map[string][]string{"a": {"http://a.com/news/1"}}, []string{}},
+		// cooked URLs are returned
+		{[]string{"http://c.com/1?foo=bar"}, map[string][]string{"c": {"http://c.com/1"}}, []string{}},
+		// no match
+		{[]string{"http://d.com/1"}, map[string][]string{}, []string{"http://d.com/1"}},
+		{[]string{}, map[string][]string{}, []string{}},
+		// a mixture
+		{
+			[]string{"http://c.com/1", "http://d.com/1", "http://a.com/1", "http://c.com/2"},
+			map[string][]string{"a": {"http://a.com/1"}, "c": {"http://c.com/1", "http://c.com/2"}},
+			[]string{"http://d.com/1"},
+		},
+	}
+
+	for _, dat := range testData {
+		groups, unmatched := routeURLs(dat.urls, scrapers)
+		if !reflect.DeepEqual(groups, dat.expectGroups) {
+			t.Errorf("%v: expected groups %v, got %v", dat.urls, dat.expectGroups, groups)
+		}
+		if !reflect.DeepEqual(unmatched, dat.expectUnmatched) {
+			t.Errorf("%v: expected unmatched %v, got %v", dat.urls, dat.expectUnmatched, unmatched)
+		}
+	}
+}
+
+func TestRunFromListFallback(t *testing.T) {
+	saved := opts.fallback
+	defer func() { opts.fallback = saved }()
+
+	a := newRouteScraper("a", "http://a.com/")
+	fb := newRouteScraper("fb", "http://fb.com/")
+	plain := newFakeScraper("plain")
+	all := map[string]Scraper{"a": a, "fb": fb, "plain": plain}
+
+	opts.fallback = "fb"
+	err := runFromList([]string{"http://a.com/1", "http://d.com/1", "http://fb.com/1"}, []Scraper{a}, all, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a.got, []string{"http://a.com/1"}) {
+		t.Errorf("a: expected [http://a.com/1], got %v", a.got)
+	}
+	// fallback gets the unmatched URLs, without its own rules applied
+	// (and only gets its "own" URLs if it's one of the targets)
+	if !reflect.DeepEqual(fb.unchecked, []string{"http://d.com/1", "http://fb.com/1"}) || len(fb.got) > 0 {
+		t.Errorf("fb: expected unchecked [http://d.com/1 http://fb.com/1], got %v (checked %v)", fb.unchecked, fb.got)
+	}
+
+	opts.fallback = "plain"
+	if err := runFromList([]string{"http://d.com/1"}, []Scraper{a}, all, nil); err == nil {
+		t.Errorf("expected error for fallback which isn't a FallbackScraper")
+	}
+	opts.fallback = "wibble"
+	if err := runFromList([]string{"http://d.com/1"}, []Scraper{a}, all, nil); err == nil {
+		t.Errorf("expected error for unknown fallback")
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+// Scheduling for scraper runs.
+// A scraper can either run at a fixed interval (eg every 3 hours), or
+// at times given by a cron-style expression. Jitter and quiet hours can
+// be applied on top of either.
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default interval between runs, if nothing else is configured
+const defaultRunPeriod = 3 * time.Hour
+
+// Schedule decides when a scraper should next run.
+type Schedule struct {
+	// Period is the interval between the starts of consecutive runs.
+	// Ignored if Cron is set.
+	Period time.Duration
+	// Cron, if set, gives the times at which runs should start.
+	Cron *CronSpec
+	// Jitter is the maximum random delay added to each scheduled run.
+	Jitter time.Duration
+	// Quiet lists daily windows during which runs shouldn't start.
+	Quiet []QuietWindow
+	// Loc is the timezone for interpreting Cron and Quiet.
+	Loc *time.Location
+}
+
+// NewSchedule builds a Schedule from the scheduling options in a scraper
+// config.
+func NewSchedule(conf *ScraperConf) (*Schedule, error) {
+	sched := &Schedule{
+		Period: defaultRunPeriod,
+		Loc:    time.Local,
+	}
+	var err error
+	if conf.RunPeriod != "" {
+		sched.Period, err = time.ParseDuration(conf.RunPeriod)
+		if err != nil {
+			return nil, fmt.Errorf("bad runperiod: %s", err)
+		}
+		if sched.Period <= 0 {
+			return nil, fmt.Errorf("bad runperiod: must be positive")
+		}
+	}
+	if conf.Cron != "" {
+		sched.Cron, err = ParseCron(conf.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("bad cron: %s", err)
+		}
+	}
+	if conf.Jitter != "" {
+		sched.Jitter, err = time.ParseDuration(conf.Jitter)
+		if err != nil {
+			return nil, fmt.Errorf("bad jitter: %s", err)
+		}
+	}
+	for _, q := range conf.QuietHours {
+		w, err := ParseQuietWindow(q)
+		if err != nil {
+			return nil, fmt.Errorf("bad quiethours: %s", err)
+		}
+		sched.Quiet = append(sched.Quiet, w)
+	}
+	return sched, nil
+}
+
+// Next returns the time at which the next run should start, given the
+// start time of the previous run and the current time.
+func (sched *Schedule) Next(lastRun time.Time, now time.Time) time.Time {
+	var next time.Time
+	if sched.Cron != nil {
+		next = sched.Cron.Next(now.In(sched.Loc))
+	}
+	if next.IsZero() {
+		next = lastRun.Add(sched.Period)
+		if next.Before(now) {
+			next = now
+		}
+	}
+
+	if sched.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(sched.Jitter))))
+	}
+	return sched.AvoidQuiet(next)
+}
+
+// First returns the time at which the first run should start, for a
+// scraper starting up at now. It's as if a run had just been due, so
+// cron, jitter and quiet hours all apply.
+func (sched *Schedule) First(now time.Time) time.Time {
+	return sched.Next(now.Add(-sched.Period), now)
+}
+
+// AvoidQuiet returns t, pushed back to the end of any quiet periods it
+// falls in.
+func (sched *Schedule) AvoidQuiet(t time.Time) time.Time {
+	// loop to handle adjacent/overlapping windows
+	for i := 0; i < len(sched.Quiet)+1; i++ {
+		moved := false
+		for _, w := range sched.Quiet {
+			if end, in := w.Contains(t.In(sched.Loc)); in {
+				t = end
+				moved = true
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+	return t
+}
+
+// Describe returns a concise description of the schedule, for logging.
+func (sched *Schedule) Describe() string {
+	var s string
+	if sched.Cron != nil {
+		s = fmt.Sprintf("cron \"%s\"", sched.Cron.Expr)
+	} else {
+		s = fmt.Sprintf("every %s", sched.Period)
+	}
+	if sched.Jitter > 0 {
+		s += fmt.Sprintf(", jitter %s", sched.Jitter)
+	}
+	for _, w := range sched.Quiet {
+		s += fmt.Sprintf(", quiet %s", w)
+	}
+	return s
+}
+
+// QuietWindow is a daily period (eg "23:00-06:00") in which no runs should
+// be started. Windows can wrap around midnight.
+type QuietWindow struct {
+	From int // minutes since midnight
+	To   int
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("bad time '%s' (expected HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ParseQuietWindow parses a window of the form "HH:MM-HH:MM".
+func ParseQuietWindow(s string) (QuietWindow, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return QuietWindow{}, fmt.Errorf("bad window '%s' (expected HH:MM-HH:MM)", s)
+	}
+	from, err := parseClock(parts[0])
+	if err != nil {
+		return QuietWindow{}, err
+	}
+	to, err := parseClock(parts[1])
+	if err != nil {
+		return QuietWindow{}, err
+	}
+	return QuietWindow{From: from, To: to}, nil
+}
+
+func (w QuietWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.From/60, w.From%60, w.To/60, w.To%60)
+}
+
+// Contains returns true if t falls inside the window, along with the time
+// at which the window ends.
+func (w QuietWindow) Contains(t time.Time) (time.Time, bool) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	m := t.Hour()*60 + t.Minute()
+	if w.From <= w.To {
+		if m >= w.From && m < w.To {
+			return midnight.Add(time.Duration(w.To) * time.Minute), true
+		}
+		return time.Time{}, false
+	}
+	// wraps around midnight
+	if m >= w.From {
+		return midnight.AddDate(0, 0, 1).Add(time.Duration(w.To) * time.Minute), true
+	}
+	if m < w.To {
+		return midnight.Add(time.Duration(w.To) * time.Minute), true
+	}
+	return time.Time{}, false
+}
+
+// CronSpec is a parsed cron-style expression, with the usual five fields:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field can be "*", a number, a range ("1-5"), a list ("1,15,30")
+// or a step ("*/15", "8-18/2").
+type CronSpec struct {
+	Expr   string
+	minute []bool // 0-59
+	hour   []bool // 0-23
+	dom    []bool // 1-31
+	month  []bool // 1-12
+	dow    []bool // 0-7 (sunday=0 or 7)
+	// were day-of-month/day-of-week restricted (ie not "*")?
+	domSet bool
+	dowSet bool
+}
+
+// ParseCron parses a cron-style expression.
+func ParseCron(expr string) (*CronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d (%s)", len(fields), expr)
+	}
+	spec := &CronSpec{Expr: expr}
+	var err error
+	if spec.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %s", err)
+	}
+	if spec.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %s", err)
+	}
+	if spec.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day-of-month: %s", err)
+	}
+	if spec.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %s", err)
+	}
+	// allow 7 as an alias for sunday
+	if spec.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day-of-week: %s", err)
+	}
+	if spec.dow[7] {
+		spec.dow[0] = true
+	}
+	spec.domSet = fields[2] != "*"
+	spec.dowSet = fields[4] != "*"
+	return spec, nil
+}
+
+// parse a single cron field into a lookup table indexed by value
+func parseCronField(field string, min int, max int) ([]bool, error) {
+	out := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx != -1 {
+			var err error
+			step, err = strconv.Atoi(part[idx+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("bad step in '%s'", part)
+			}
+			part = part[:idx]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			lo, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("bad value '%s'", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				hi, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("bad value '%s'", part)
+				}
+			} else if step > 1 {
+				// "5/15" means "5-max/15"
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("'%s' out of range (%d-%d)", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			out[v] = true
+		}
+	}
+	return out, nil
+}
+
+func (spec *CronSpec) dayMatches(t time.Time) bool {
+	domOK := spec.dom[t.Day()]
+	dowOK := spec.dow[int(t.Weekday())]
+	// standard cron behaviour: if both fields are restricted, either can match
+	if spec.domSet && spec.dowSet {
+		return domOK || dowOK
+	}
+	return domOK && dowOK
+}
+
+// Next returns the first matching time strictly after t.
+// Returns the zero time if there's no match in the next few years
+// (eg "0 0 31 2 *").
+func (spec *CronSpec) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !spec.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !spec.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !spec.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !spec.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	mustTime := func(s string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			panic("bad time: " + s)
+		}
+		return t
+	}
+
+	testData := []struct {
+		expr   string
+		from   string
+		expect string
+	}{
+		{"*/15 * * * *", "2017-01-05 10:07", "2017-01-05 10:15"},
+		{"0 * * * *", "2017-01-05 10:00", "2017-01-05 11:00"},
+		{"30 6 * * *", "2017-01-05 10:00", "2017-01-06 06:30"},
+		{"0 8-18/2 * * *", "2017-01-05 18:01", "2017-01-06 08:00"},
+		// 2017-01-05 is a thursday
+		{"0 9 * * 1", "2017-01-05 10:00", "2017-01-09 09:00"},
+		{"0 9 * * 7", "2017-01-05 10:00", "2017-01-08 09:00"},
+		{"0 0 1 * *", "2017-01-05 10:00", "2017-02-01 00:00"},
+		// dom and dow both restricted => either matches
+		{"0 0 20 * 5", "2017-01-05 10:00", "2017-01-06 00:00"},
+		{"0 0 29 2 *", "2017-03-01 00:00", "2020-02-29 00:00"},
+	}
+
+	for _, dat := range testData {
+		spec, err := ParseCron(dat.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q) failed: %s", dat.expr, err)
+			continue
+		}
+		got := spec.Next(mustTime(dat.from))
+		expect := mustTime(dat.expect)
+		if !got.Equal(expect) {
+			t.Errorf("%q from %s: got %s, expected %s", dat.expr, dat.from, got, expect)
+		}
+	}
+
+	for _, bad := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(bad); err == nil {
+			t.Errorf("ParseCron(%q) should have failed", bad)
+		}
+	}
+}
+
+func TestQuietWindow(t *testing.T) {
+	sched := &Schedule{Period: time.Hour, Loc: time.UTC}
+	w, err := ParseQuietWindow("23:00-06:00")
+	if err != nil {
+		t.Fatalf("ParseQuietWindow failed: %s", err)
+	}
+	sched.Quiet = append(sched.Quiet, w)
+
+	last := time.Date(2017, 1, 5, 22, 30, 0, 0, time.UTC)
+	got := sched.Next(last, last)
+	expect := time.Date(2017, 1, 6, 6, 0, 0, 0, time.UTC)
+	if !got.Equal(expect) {
+		t.Errorf("got %s, expected %s", got, expect)
+	}
+
+	last = time.Date(2017, 1, 5, 12, 0, 0, 0, time.UTC)
+	got = sched.Next(last, last)
+	expect = time.Date(2017, 1, 5, 13, 0, 0, 0, time.UTC)
+	if !got.Equal(expect) {
+		t.Errorf("got %s, expected %s", got, expect)
+	}
+}
+
+func TestFirstRun(t *testing.T) {
+	now := time.Date(2017, 1, 5, 23, 30, 0, 0, time.UTC)
+	sched := &Schedule{Period: time.Hour, Loc: time.UTC}
+
+	// no restrictions => run straight away
+	if got := sched.First(now); !got.Equal(now) {
+		t.Errorf("got %s, expected %s", got, now)
+	}
+
+	// starting up in a quiet window
+	w, err := ParseQuietWindow("23:00-06:00")
+	if err != nil {
+		t.Fatalf("ParseQuietWindow failed: %s", err)
+	}
+	sched.Quiet = append(sched.Quiet, w)
+	expect := time.Date(2017, 1, 6, 6, 0, 0, 0, time.UTC)
+	if got := sched.First(now); !got.Equal(expect) {
+		t.Errorf("quiet: got %s, expected %s", got, expect)
+	}
+
+	// cron => wait for the first matching time
+	sched.Quiet = nil
+	sched.Cron, err = ParseCron("0 9 * * *")
+	if err != nil {
+		t.Fatalf("ParseCron failed: %s", err)
+	}
+	expect = time.Date(2017, 1, 6, 9, 0, 0, 0, time.UTC)
+	if got := sched.First(now); !got.Equal(expect) {
+		t.Errorf("cron: got %s, expected %s", got, expect)
+	}
+}
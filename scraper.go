@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"github.com/bcampbell/arts/arts"
-	"github.com/bcampbell/arts/util"
 	"github.com/bcampbell/biscuit"
 	"github.com/bcampbell/scrapeomat/arc"
 	"github.com/bcampbell/scrapeomat/discover"
+	"github.com/bcampbell/scrapeomat/extract"
+	"github.com/bcampbell/scrapeomat/fetch"
 	"github.com/bcampbell/scrapeomat/paywall"
 	"github.com/bcampbell/scrapeomat/store"
 	"io/ioutil"
@@ -16,6 +20,10 @@ import (
 	"net/http/cookiejar"
 	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,43 +34,242 @@ type ScrapeStats struct {
 	FetchCount int
 
 	StashCount int
+	// ArchiveCount is the number of articles taken from the archive
+	// instead of being fetched
+	ArchiveCount int
+	// RetryCount is the number of retries made for transient HTTP
+	// failures, GiveUpCount the number of articles still failing after
+	// all retries (these also count as errors).
+	RetryCount  int
+	GiveUpCount int
+	// DisallowedCount is the number of articles skipped because
+	// robots.txt forbids fetching them
+	DisallowedCount int
+	// Rejected counts the pages which were fetched OK but not accepted
+	// as articles, by reason (eg redirected to a non-article, failed
+	// the acceptance rules)
+	Rejected map[string]int
+	// FoundCount is the number of candidate article URLs (from discovery
+	// or list), NewCount the number not already in the store
+	FoundCount int
+	NewCount   int
+	Discovery  discover.DiscoverStats
 }
 
-// TODO: factor out a scraper interface, to handle both generic and custom scrapers
-// Name() string
-// Discover(c *http.Client) ([]string, error)
-// DoRun(db *store.Store, c *http.Client) error
-// DoRunFromList(arts []string, db *store.Store, c *http.Client) error
+// Scraper is the interface which all scrapers implement, be they the
+// generic config-driven kind (GenericScraper) or custom ones with special
+// handling for particular sites.
+// See RegisterScraperType() for plugging in new implementations.
+type Scraper interface {
+	Name() string
+	// Login performs any paywall login required before discovery/scraping.
+	Login() error
+	// Discover returns a list of article URLs found on the site.
+	Discover() ([]string, error)
+	// DoRun performs a single scraper run (discovery, then scraping).
+	DoRun(db store.Store) error
+	// DoRunFromList performs a single scraper run, using the supplied list
+	// of article URLs instead of discovery.
+	DoRunFromList(arts []string, db store.Store, updateMode bool) error
+	// Start runs the scraper at regular intervals until Stop() is called.
+	Start(db store.Store)
+	// Stop asks the scraper to stop, at the next opportunity.
+	Stop()
+}
+
+// DiscoveryReporter is implemented by scrapers which can report the
+// details of their discovery phase (see the -report flag).
+type DiscoveryReporter interface {
+	// DiscoverReport is like Discover, but also returns a report of the
+	// pages visited and links found.
+	DiscoverReport() ([]string, *discover.Report, error)
+}
+
+// Controllable is implemented by scrapers which can be controlled while
+// running continuously (see the -control flag).
+type Controllable interface {
+	// State returns what the scraper is up to: "idle", "discovering",
+	// "scraping", "sleeping", "paused" or "stopped".
+	State() string
+	// RunNow wakes up a sleeping scraper to start a run immediately.
+	RunNow() error
+	// Pause stops any new runs from starting until Resume() is called.
+	// A run already in progress is allowed to finish.
+	Pause()
+	Resume()
+	// Paused returns true if the scraper has been paused.
+	Paused() bool
+	// NextRun returns the time of the next scheduled run (zero if not
+	// yet known).
+	NextRun() time.Time
+	// SetNextRun sets the time of the first run, before calling Start()
+	// (eg to carry on the schedule of a scraper being replaced).
+	SetNextRun(t time.Time)
+}
 
-type Scraper struct {
-	Name       string
+// GenericScraper is a scraper driven entirely by a ScraperConf.
+type GenericScraper struct {
+	name       string
 	Conf       *ScraperConf
 	discoverer *discover.Discoverer
 	errorLog   *log.Logger
 	infoLog    *log.Logger
 	archiveDir string
-	stats      ScrapeStats
-	runPeriod  time.Duration
-	client     *http.Client
-	quit       chan struct{}
+	// don't archive fetched articles (eg when explaining)
+	noArchive bool
+	// file for remembering nav pages between runs ("" = don't)
+	pageCacheFile string
+	stats         ScrapeStats
+	statsLock     sync.Mutex
+	schedule      *Schedule
+	// max age of archived articles usable in archive-first mode (0=any)
+	archiveMaxAge time.Duration
+	accept        *acceptRules
+	override      *extract.Override
+	// details from the last discovery (eg feed titles), by article url
+	hints map[string]discover.Hint
+	// zone for timestamps with no timezone (nil=leave them alone)
+	loc      *time.Location
+	client   *http.Client
+	retry    fetch.Policy
+	robots   *fetch.RobotsCache // nil if ignoring robots.txt
+	quit     chan struct{}
+	quitOnce sync.Once
+	// ctx is cancelled by Stop(), to abandon requests in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+	// wake is used to request an immediate run
+	wake chan struct{}
+	// runLock stops runs overlapping (eg a list submitted via the control
+	// API while the scraper is running continuously)
+	runLock sync.Mutex
+
+	stateLock sync.Mutex
+	activity  string // "discovering", "scraping" or "" if not running
+	paused    bool
+	looping   bool      // inside Start()?
+	nextRun   time.Time // next scheduled run
 }
 
 type ScraperConf struct {
 	discover.DiscovererDef
+	// selectors to fix up extraction (see doc/scraper_config.md)
+	extract.OverrideDef
+	// Type selects the scraper implementation (default "generic")
+	Type       string
 	Cookies    bool
 	CookieFile string
 	PubCode    string
+
+	// scheduling options (see doc/scraper_config.md)
+	RunPeriod  string
+	Cron       string
+	Jitter     string
+	QuietHours []string
+
+	// Workers is the number of articles to fetch and scrape in parallel
+	// (default 1). The per-host politeness delay still applies.
+	Workers int
+
+	// ArchiveFirst means articles already in the archive dir will be
+	// used instead of fetching them again.
+	ArchiveFirst bool
+	// ArchiveMaxAge is the maximum age of archived articles to accept
+	// in ArchiveFirst mode (eg "720h"). Default is no limit.
+	ArchiveMaxAge string
+
+	// retry options for transient HTTP failures.
+	// Retries is the max number of retries per request (0 = default, -1 =
+	// no retries). RetryDelay is the initial backoff, doubling each time,
+	// up to MaxRetryDelay.
+	Retries       int
+	RetryDelay    string
+	MaxRetryDelay string
+
+	// Timezone is the zone (eg "America/Mexico_City") to assume for
+	// published/updated timestamps which don't include one. Default is UTC.
+	Timezone string
+
+	// HTTP options, applied to discovery, login and article fetching.
+	// Proxy is the URL of a proxy to use (default is to use the
+	// HTTP_PROXY/HTTPS_PROXY environment variables).
+	// Header holds extra request headers ("Name: value").
+	// Timeout limits the time for a single request (default "1m", "0"
+	// for no limit).
+	// Delay is the minimum delay between requests to the same host
+	// (default "1s").
+	// TLSInsecure turns off certificate checking, TLSCACert adds extra
+	// trusted CA certificates (PEM file).
+	Proxy       string
+	Header      []string
+	Timeout     string
+	Delay       string
+	TLSInsecure bool
+	TLSCACert   string
+
+	// acceptance rules, applied to scraped articles.
+	// MinLength is the minimum length of the content text (in characters).
+	// Require lists fields which must be present (headline, published,
+	// content, authors, section).
+	// XHeadline and XSection are regexps which reject an article if they
+	// match its headline or section.
+	// XArtSel is a CSS selector which rejects the page if it matches.
+	MinLength int
+	Require   []string
+	XHeadline []string
+	XSection  []string
+	XArtSel   string
+
+	// IgnoreRobots disables robots.txt checking (eg for sites which have
+	// given explicit permission). Crawl-delay is ignored too.
+	IgnoreRobots bool
 }
 
 var ErrQuit = errors.New("quit requested")
 
-func NewScraper(name string, conf *ScraperConf, verbosity int, archiveDir string) (*Scraper, error) {
-	scraper := Scraper{
-		Name:       name,
+func NewGenericScraper(name string, conf *ScraperConf, verbosity int, archiveDir string, stateDir string) (*GenericScraper, error) {
+	scraper := GenericScraper{
+		name:       name,
 		Conf:       conf,
 		archiveDir: archiveDir,
-		runPeriod:  3 * time.Hour,
-		quit:       make(chan struct{}, 1),
+		quit:       make(chan struct{}),
+		wake:       make(chan struct{}, 1),
+	}
+	scraper.ctx, scraper.cancel = context.WithCancel(context.Background())
+
+	sched, err := NewSchedule(conf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	scraper.schedule = sched
+
+	scraper.retry, err = buildRetryPolicy(conf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	if conf.ArchiveMaxAge != "" {
+		scraper.archiveMaxAge, err = time.ParseDuration(conf.ArchiveMaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad archivemaxage: %s", name, err)
+		}
+	}
+
+	scraper.override, err = extract.NewOverride(conf.OverrideDef)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	scraper.accept, err = buildAcceptRules(conf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	if conf.Timezone != "" {
+		scraper.loc, err = time.LoadLocation(conf.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad timezone: %s", name, err)
+		}
 	}
 
 	scraper.errorLog = log.New(os.Stderr, "ERR "+name+": ", 0)
@@ -78,16 +285,35 @@ func NewScraper(name string, conf *ScraperConf, verbosity int, archiveDir string
 		return nil, err
 	}
 	disc.ErrorLog = scraper.errorLog
+	disc.Retry = scraper.retry
 	if verbosity > 1 {
 		disc.InfoLog = scraper.infoLog
 	}
 	scraper.discoverer = disc
 
+	if stateDir != "" {
+		scraper.pageCacheFile = filepath.Join(stateDir, name+".pages.json")
+		cache, err := discover.LoadPageCache(scraper.pageCacheFile)
+		if err != nil {
+			// not fatal - we'll just rescan everything
+			scraper.errorLog.Printf("bad page cache (%s): %s\n", scraper.pageCacheFile, err)
+			cache = &discover.PageCache{}
+		}
+		disc.PageCache = cache
+	}
+
 	// create the http client
 	// use politetripper to avoid hammering servers
 	var c *http.Client
-	transport := util.NewPoliteTripper()
-	transport.PerHostDelay = 1 * time.Second
+	transport, err := buildTransport(conf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	hdr, err := buildHeaders(conf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	headers := &fetch.HeaderTripper{Header: hdr, Transport: transport}
 
 	if conf.Cookies || (conf.CookieFile != "") {
 		jar, err := cookiejar.New(nil)
@@ -116,22 +342,36 @@ func NewScraper(name string, conf *ScraperConf, verbosity int, archiveDir string
 			jar.SetCookies(host, cookies)
 		}
 		c = &http.Client{
-			Transport: transport,
-			Jar:       jar,
+			Transport:     &countingTripper{name: name, next: headers},
+			CheckRedirect: fetch.CheckRedirect,
+			Jar:           jar,
 		}
 
 	} else {
 		c = &http.Client{
-			Transport: transport,
+			Transport:     &countingTripper{name: name, next: headers},
+			CheckRedirect: fetch.CheckRedirect,
 		}
 	}
 	scraper.client = c
 
+	// robots.txt rules are shared by discovery and article fetching
+	if !conf.IgnoreRobots {
+		scraper.robots = fetch.NewRobotsCache(c, conf.UserAgent)
+		scraper.robots.Polite = transport
+		scraper.robots.ErrorLog = scraper.errorLog
+		disc.Robots = scraper.robots
+	}
+
 	return &scraper, nil
 }
 
-func (scraper *Scraper) Login() error {
-	login := paywall.GetLogin(scraper.Name)
+func (scraper *GenericScraper) Name() string {
+	return scraper.name
+}
+
+func (scraper *GenericScraper) Login() error {
+	login := paywall.GetLogin(scraper.name)
 	if login != nil {
 		scraper.infoLog.Printf("Logging in\n")
 		err := login(scraper.client)
@@ -142,7 +382,7 @@ func (scraper *Scraper) Login() error {
 	return nil
 }
 
-func (scraper *Scraper) Discover() ([]string, error) {
+func (scraper *GenericScraper) Discover() ([]string, error) {
 	disc := scraper.discoverer
 
 	artLinks, err := disc.Run(scraper.client, scraper.quit)
@@ -152,6 +392,17 @@ func (scraper *Scraper) Discover() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	scraper.hints = disc.Hints
+
+	if scraper.pageCacheFile != "" {
+		err = os.MkdirAll(filepath.Dir(scraper.pageCacheFile), 0777)
+		if err == nil {
+			err = disc.PageCache.Save(scraper.pageCacheFile)
+		}
+		if err != nil {
+			scraper.errorLog.Printf("failed to save page cache: %s\n", err)
+		}
+	}
 
 	foundArts := make([]string, 0, len(artLinks))
 	for l, _ := range artLinks {
@@ -160,9 +411,59 @@ func (scraper *Scraper) Discover() ([]string, error) {
 	return foundArts, nil
 }
 
+// DiscoverReport runs discovery, returning a report with the details
+// alongside the article URLs.
+func (scraper *GenericScraper) DiscoverReport() ([]string, *discover.Report, error) {
+	report := &discover.Report{}
+	scraper.discoverer.Report = report
+	defer func() { scraper.discoverer.Report = nil }()
+	foundArts, err := scraper.Discover()
+	report.Name = scraper.name
+	return foundArts, report, err
+}
+
 // start the scraper, running it at regular intervals
-func (scraper *Scraper) Start(db store.Store) {
+func (scraper *GenericScraper) Start(db store.Store) {
+	scraper.infoLog.Printf("schedule: %s\n", scraper.schedule.Describe())
+	scraper.setLooping(true)
+	defer scraper.setLooping(false)
+
+	// first run is as soon as the schedule allows, unless SetNextRun()
+	// said otherwise (and even then, respect quiet hours)
+	nextRun := scraper.NextRun()
+	if nextRun.IsZero() {
+		nextRun = scraper.schedule.First(time.Now())
+	} else {
+		nextRun = scraper.schedule.AvoidQuiet(nextRun)
+	}
+	scraper.SetNextRun(nextRun)
 	for {
+		delay := time.Until(nextRun)
+		if delay > 0 {
+			scraper.infoLog.Printf("next run at %s (sleeping for %s)\n", nextRun.Format(time.RFC3339), delay)
+		}
+		// wait for next run, or a quit request
+		requested := false
+		select {
+		case <-scraper.quit:
+			scraper.infoLog.Printf("Quit requested!\n")
+			return
+		case <-scraper.wake:
+			scraper.infoLog.Printf("Run requested!\n")
+			requested = true
+		case <-time.After(delay):
+			if delay > 0 {
+				scraper.infoLog.Printf("Wakeup!\n")
+			}
+		}
+
+		if !requested && scraper.Paused() {
+			scraper.infoLog.Printf("Paused - skipping run\n")
+			nextRun = scraper.schedule.Next(nextRun, time.Now())
+			scraper.SetNextRun(nextRun)
+			continue
+		}
+
 		lastRun := time.Now()
 		err := scraper.DoRun(db)
 		if err == ErrQuit {
@@ -172,46 +473,136 @@ func (scraper *Scraper) Start(db store.Store) {
 		if err != nil {
 			scraper.errorLog.Printf("run aborted: %s", err)
 		}
+		nextRun = scraper.schedule.Next(lastRun, time.Now())
+		scraper.SetNextRun(nextRun)
+	}
+}
 
-		nextRun := lastRun.Add(scraper.runPeriod)
-		delay := nextRun.Sub(time.Now())
-		scraper.infoLog.Printf("next run at %s (sleeping for %s)\n", nextRun.Format(time.RFC3339), delay)
-		// wait for next run, or a quit request
+// stop the scraper, at the next opportunity
+func (scraper *GenericScraper) Stop() {
+	// closing the channel means everyone waiting on it sees the request
+	scraper.quitOnce.Do(func() {
+		close(scraper.quit)
+		scraper.cancel()
+	})
+}
+
+func (scraper *GenericScraper) stopped() bool {
+	select {
+	case <-scraper.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// State returns what the scraper is currently doing.
+func (scraper *GenericScraper) State() string {
+	scraper.stateLock.Lock()
+	defer scraper.stateLock.Unlock()
+	switch {
+	case scraper.activity != "":
+		return scraper.activity
+	case scraper.stopped():
+		return "stopped"
+	case scraper.paused:
+		return "paused"
+	case scraper.looping:
+		return "sleeping"
+	default:
+		return "idle"
+	}
+}
+
+// RunNow asks a sleeping scraper to start a run immediately.
+// It fails if the scraper is paused or stopped, or isn't running
+// continuously.
+func (scraper *GenericScraper) RunNow() error {
+	state := scraper.State()
+	switch state {
+	case "sleeping":
+		// (if there's already a wakeup pending, that'll do)
 		select {
-		case <-scraper.quit:
-			scraper.infoLog.Printf("Quit requested!\n")
-			return
-		case <-time.After(delay):
-			scraper.infoLog.Printf("Wakeup!\n")
+		case scraper.wake <- struct{}{}:
+		default:
 		}
+		return nil
+	case "discovering", "scraping":
+		return fmt.Errorf("already running")
+	default:
+		return fmt.Errorf("can't run (scraper is %s)", state)
 	}
 }
 
-// stop the scraper, at the next opportunity
-func (scraper *Scraper) Stop() {
-	scraper.quit <- struct{}{}
+// Pause stops new runs from starting until Resume() is called.
+func (scraper *GenericScraper) Pause() {
+	scraper.stateLock.Lock()
+	defer scraper.stateLock.Unlock()
+	scraper.paused = true
+}
+
+// Resume undoes Pause(). Runs start again at the next scheduled time.
+func (scraper *GenericScraper) Resume() {
+	scraper.stateLock.Lock()
+	defer scraper.stateLock.Unlock()
+	scraper.paused = false
+}
+
+// NextRun returns the time of the next scheduled run.
+func (scraper *GenericScraper) NextRun() time.Time {
+	scraper.stateLock.Lock()
+	defer scraper.stateLock.Unlock()
+	return scraper.nextRun
+}
+
+// SetNextRun sets the time of the next run. Call it before Start() to
+// delay the first run.
+func (scraper *GenericScraper) SetNextRun(t time.Time) {
+	scraper.stateLock.Lock()
+	scraper.nextRun = t
+	scraper.stateLock.Unlock()
+	metrics.SetNextRun(scraper.name, t)
+}
+
+// Paused returns true if Pause() is in effect.
+func (scraper *GenericScraper) Paused() bool {
+	scraper.stateLock.Lock()
+	defer scraper.stateLock.Unlock()
+	return scraper.paused
+}
+
+func (scraper *GenericScraper) setLooping(looping bool) {
+	scraper.stateLock.Lock()
+	defer scraper.stateLock.Unlock()
+	scraper.looping = looping
+}
+
+func (scraper *GenericScraper) setActivity(activity string) {
+	scraper.stateLock.Lock()
+	defer scraper.stateLock.Unlock()
+	scraper.activity = activity
 }
 
 // perform a single scraper run
-func (scraper *Scraper) DoRun(db store.Store) error {
+func (scraper *GenericScraper) DoRun(db store.Store) (err error) {
+	scraper.runLock.Lock()
+	defer scraper.runLock.Unlock()
 
 	scraper.infoLog.Printf("start run\n")
-	// reset the stats
-	scraper.stats = ScrapeStats{}
-	scraper.stats.Start = time.Now()
+	scraper.startRun()
+	scraper.setActivity("discovering")
 	defer func() {
-		stats := &scraper.stats
-		stats.End = time.Now()
-		elapsed := stats.End.Sub(stats.Start)
-		defer scraper.infoLog.Printf("run finished in %s (%d new articles, %d errors)\n", elapsed, stats.StashCount, stats.ErrorCount)
+		scraper.setActivity("")
+		scraper.finishRun(db, err, "run finished")
 	}()
 
-	err := scraper.Login()
+	err = scraper.Login()
 	if err != nil {
 		return err
 	}
 
 	foundArts, err := scraper.Discover()
+	scraper.stats.Discovery = scraper.discoverer.Stats
 	if err != nil {
 		return err
 	}
@@ -220,14 +611,188 @@ func (scraper *Scraper) DoRun(db store.Store) error {
 	if err != nil {
 		return fmt.Errorf("WhichAreNew() failed: %s", err)
 	}
+	scraper.stats.FoundCount = len(foundArts)
+	scraper.stats.NewCount = len(newArts)
 
 	stats := scraper.discoverer.Stats
-	scraper.infoLog.Printf("found %d articles, %d new (%d pages fetched, %d errors)\n",
-		len(foundArts), len(newArts), stats.FetchCount, stats.ErrorCount)
+	scraper.infoLog.Printf("found %d articles, %d new (%d pages fetched, %d unchanged, %d errors, %d retries, %d gave up, %d disallowed)\n",
+		len(foundArts), len(newArts), stats.FetchCount, stats.UnchangedCount, stats.ErrorCount, stats.RetryCount, stats.GiveUpCount, stats.DisallowedCount)
+	if stats.LimitHit != "" {
+		scraper.infoLog.Printf("discovery stopped early (%s limit reached)\n", stats.LimitHit)
+	}
 
+	scraper.setActivity("scraping")
 	return scraper.FetchAndStash(newArts, db, false)
 }
 
+// buildRetryPolicy sets up the retry policy from the scraper config.
+func buildRetryPolicy(conf *ScraperConf) (fetch.Policy, error) {
+	policy := fetch.DefaultPolicy
+	if conf.Retries < 0 {
+		policy.MaxRetries = 0
+	} else if conf.Retries > 0 {
+		policy.MaxRetries = conf.Retries
+	}
+	var err error
+	if conf.RetryDelay != "" {
+		policy.BaseDelay, err = time.ParseDuration(conf.RetryDelay)
+		if err != nil {
+			return policy, fmt.Errorf("bad retrydelay: %s", err)
+		}
+	}
+	if conf.MaxRetryDelay != "" {
+		policy.MaxDelay, err = time.ParseDuration(conf.MaxRetryDelay)
+		if err != nil {
+			return policy, fmt.Errorf("bad maxretrydelay: %s", err)
+		}
+	}
+	return policy, nil
+}
+
+// default timeout for a single HTTP request
+const defaultTimeout = 1 * time.Minute
+
+// buildTransport sets up the http transport described by the HTTP options
+// in conf. Returns the PoliteTripper at the top of the stack (which
+// robots.txt Crawl-delays are applied to).
+func buildTransport(conf *ScraperConf) (*fetch.PoliteTripper, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	if conf.Proxy != "" {
+		proxyURL, err := url.Parse(conf.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy: %s", err)
+		}
+		if proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("bad proxy: '%s' is not an absolute URL", conf.Proxy)
+		}
+		base.Proxy = http.ProxyURL(proxyURL)
+	}
+	if conf.TLSInsecure || conf.TLSCACert != "" {
+		tlsConf := &tls.Config{InsecureSkipVerify: conf.TLSInsecure}
+		if conf.TLSCACert != "" {
+			pem, err := ioutil.ReadFile(conf.TLSCACert)
+			if err != nil {
+				return nil, fmt.Errorf("bad tlscacert: %s", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("bad tlscacert: no certificates found in %s", conf.TLSCACert)
+			}
+			tlsConf.RootCAs = pool
+		}
+		base.TLSClientConfig = tlsConf
+	}
+
+	timeout := defaultTimeout
+	if conf.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(conf.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("bad timeout: %s", err)
+		}
+	}
+
+	polite := fetch.NewPoliteTripper()
+	if conf.Delay != "" {
+		var err error
+		polite.PerHostDelay, err = time.ParseDuration(conf.Delay)
+		if err != nil {
+			return nil, fmt.Errorf("bad delay: %s", err)
+		}
+	}
+	// (timeout applies after the politeness delay)
+	polite.Transport = &fetch.TimeoutTripper{Timeout: timeout, Transport: base}
+	return polite, nil
+}
+
+// buildHeaders collects the extra headers from conf (including the
+// useragent, and a default Accept header).
+func buildHeaders(conf *ScraperConf) (http.Header, error) {
+	hdr := http.Header{}
+	for _, line := range conf.Header {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("bad header '%s' (expected \"Name: value\")", line)
+		}
+		hdr.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	if conf.UserAgent != "" && hdr.Get("User-Agent") == "" {
+		hdr.Set("User-Agent", conf.UserAgent)
+	}
+	// NOTE: FT.com always returns 403 if no Accept header is present.
+	// Seems like a reasonable thing to send anyway...
+	if hdr.Get("Accept") == "" {
+		hdr.Set("Accept", "*/*")
+	}
+	return hdr, nil
+}
+
+// reset the stats at the start of a run
+func (scraper *GenericScraper) startRun() {
+	scraper.stats = ScrapeStats{}
+	scraper.stats.Start = time.Now()
+	metrics.RunStarted(scraper.name, scraper.stats.Start)
+}
+
+// finishRun logs a summary of the run and records it in the store.
+func (scraper *GenericScraper) finishRun(db store.Store, runErr error, what string) {
+	stats := &scraper.stats
+	stats.End = time.Now()
+	elapsed := stats.End.Sub(stats.Start)
+	scraper.infoLog.Printf("%s in %s (%d new articles, %d errors, %d retries, %d gave up)\n", what, elapsed, stats.StashCount, stats.ErrorCount, stats.RetryCount, stats.GiveUpCount)
+	if stats.ArchiveCount > 0 {
+		scraper.infoLog.Printf("%d articles taken from archive\n", stats.ArchiveCount)
+	}
+	if len(stats.Rejected) > 0 {
+		reasons := make([]string, 0, len(stats.Rejected))
+		for reason, _ := range stats.Rejected {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			scraper.infoLog.Printf("%d articles rejected (%s)\n", stats.Rejected[reason], reason)
+		}
+	}
+	if stats.DisallowedCount > 0 {
+		scraper.infoLog.Printf("%d articles skipped (disallowed by robots.txt)\n", stats.DisallowedCount)
+	}
+
+	run := &store.ScrapeRun{
+		Scraper:      scraper.name,
+		Started:      stats.Start,
+		Finished:     stats.End,
+		PagesFetched: stats.Discovery.FetchCount,
+		PageErrors:   stats.Discovery.ErrorCount,
+		ArtsFound:    stats.FoundCount,
+		ArtsNew:      stats.NewCount,
+		ArtsStashed:  stats.StashCount,
+		ArtErrors:    stats.ErrorCount,
+	}
+	if runErr != nil {
+		run.AbortReason = runErr.Error()
+	}
+	metrics.RunFinished(scraper.name, stats, runErr == nil)
+	_, err := db.StashRun(run)
+	if err != nil {
+		scraper.errorLog.Printf("failed to record run: %s\n", err)
+	}
+}
+
+// checkAbsURL makes sure an article URL is something we can fetch
+func checkAbsURL(artURL string) error {
+	u, err := url.Parse(artURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("not an http(s) url")
+	}
+	return nil
+}
+
 func uniq(in []string) []string {
 	foo := map[string]struct{}{}
 	for _, s := range in {
@@ -240,40 +805,62 @@ func uniq(in []string) []string {
 	return out
 }
 
+// CookArticleURL applies the site URL rules to an article URL, returning
+// the normalised version (or an error if it's rejected).
+func (scraper *GenericScraper) CookArticleURL(artURL string) (string, error) {
+	baseURL := scraper.discoverer.StartURL
+	cooked, err := scraper.discoverer.CookArticleURL(&baseURL, artURL)
+	if err != nil {
+		return "", err
+	}
+	return cooked.String(), nil
+}
+
 // perform a single scraper run, using a list of article URLS instead of invoking the discovery
-func (scraper *Scraper) DoRunFromList(arts []string, db store.Store, updateMode bool) error {
+func (scraper *GenericScraper) DoRunFromList(arts []string, db store.Store, updateMode bool) error {
+	return scraper.doRunFromList(arts, db, updateMode, true)
+}
+
+// DoRunFromListUnchecked performs a run from a list of article URLs
+// without applying the site rules (for use as a -fallback scraper).
+func (scraper *GenericScraper) DoRunFromListUnchecked(arts []string, db store.Store, updateMode bool) error {
+	return scraper.doRunFromList(arts, db, updateMode, false)
+}
+
+func (scraper *GenericScraper) doRunFromList(arts []string, db store.Store, updateMode bool, checkRules bool) (err error) {
+	scraper.runLock.Lock()
+	defer scraper.runLock.Unlock()
 
 	scraper.infoLog.Printf("start run from list\n")
-	// reset the stats
-	scraper.stats = ScrapeStats{}
-	scraper.stats.Start = time.Now()
+	scraper.startRun()
+	scraper.setActivity("scraping")
 	defer func() {
-		stats := &scraper.stats
-		stats.End = time.Now()
-		elapsed := stats.End.Sub(stats.Start)
-		defer scraper.infoLog.Printf("finished in %s (%d new articles, %d errors)\n", elapsed, stats.StashCount, stats.ErrorCount)
+		scraper.setActivity("")
+		scraper.finishRun(db, err, "finished")
 	}()
 
-	// use base url from the discovery config
-	baseURL := scraper.discoverer.StartURL
-
 	// process/reject urls using site rules
 	cookedArts := []string{}
 	rejectCnt := 0
 	for _, artURL := range arts {
-		cooked, err := scraper.discoverer.CookArticleURL(&baseURL, artURL)
+		var cooked string
+		var err error
+		if checkRules {
+			cooked, err = scraper.CookArticleURL(artURL)
+		} else {
+			cooked, err = artURL, checkAbsURL(artURL)
+		}
 		if err != nil {
 			scraper.infoLog.Printf("Reject %s (%s)\n", artURL, err)
 			rejectCnt++
 			continue
 		}
-		cookedArts = append(cookedArts, cooked.String())
+		cookedArts = append(cookedArts, cooked)
 	}
 
 	// remove any dupes
 	cookedArts = uniq(cookedArts)
 
-	var err error
 	var newArts []string
 	if !updateMode {
 		newArts, err = db.WhichAreNew(cookedArts)
@@ -287,6 +874,8 @@ func (scraper *Scraper) DoRunFromList(arts []string, db store.Store, updateMode
 
 	scraper.infoLog.Printf("%d articles, %d rejected\n",
 		len(newArts), rejectCnt)
+	scraper.stats.FoundCount = len(arts)
+	scraper.stats.NewCount = len(newArts)
 
 	err = scraper.Login()
 	if err != nil {
@@ -296,124 +885,320 @@ func (scraper *Scraper) DoRunFromList(arts []string, db store.Store, updateMode
 	return scraper.FetchAndStash(newArts, db, updateMode)
 }
 
-func (scraper *Scraper) checkQuit() bool {
-	select {
-	case <-scraper.quit:
-		return true
-	default:
-		return false
+// FetchAndStash fetches, scrapes and stores a list of articles.
+// The work is spread across a pool of workers (see ScraperConf.Workers).
+// Returns ErrQuit if Stop() is called, or an error if too many articles fail.
+func (scraper *GenericScraper) FetchAndStash(newArts []string, db store.Store, updateMode bool) error {
+	numWorkers := scraper.Conf.Workers
+	if numWorkers < 1 {
+		numWorkers = 1
 	}
-}
+	maxErrors := 100 + len(newArts)/10
 
-func (scraper *Scraper) FetchAndStash(newArts []string, db store.Store, updateMode bool) error {
-	//scraper.infoLog.Printf("Start scraping\n")
+	jobs := make(chan string)
+	// closed by a worker if the error threshold is exceeded
+	abort := make(chan struct{})
+	var abortOnce sync.Once
+	var abortErr error
 
-	// fetch and extract 'em
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for artURL := range jobs {
+				err := scraper.fetchAndStashOne(artURL, db, updateMode)
+				if err == nil || err == ErrQuit {
+					continue
+				}
+				if err == fetch.ErrDisallowed {
+					scraper.infoLog.Printf("skipped %s (disallowed by robots.txt)\n", artURL)
+					continue
+				}
+				if rerr, ok := err.(*rejectError); ok {
+					scraper.infoLog.Printf("rejected %s\n", rerr)
+					continue
+				}
+				scraper.errorLog.Printf("%s\n", err)
+				errCnt := scraper.incErrorCount()
+				if errCnt > maxErrors {
+					abortOnce.Do(func() {
+						abortErr = fmt.Errorf("too many errors (%d)", errCnt)
+						close(abort)
+					})
+				}
+			}
+		}()
+	}
+
+	// feed the workers
+	var err error
+feed:
 	for _, artURL := range newArts {
-		if scraper.checkQuit() {
-			return ErrQuit
+		// check first, so a waiting worker can't win out over a quit or
+		// abort which has already happened
+		select {
+		case <-scraper.quit:
+			err = ErrQuit
+			break feed
+		case <-abort:
+			break feed
+		default:
 		}
-
-		//		scraper.infoLog.Printf("fetch/scrape %s", artURL)
-		art, err := scraper.ScrapeArt(artURL)
-		if err != nil {
-			scraper.errorLog.Printf("%s\n", err)
-			scraper.stats.ErrorCount += 1
-			if scraper.stats.ErrorCount > 100+len(newArts)/10 {
-				return fmt.Errorf("too many errors (%d)", scraper.stats.ErrorCount)
-			}
-			continue
+		select {
+		case <-scraper.quit:
+			err = ErrQuit
+			break feed
+		case <-abort:
+			break feed
+		case jobs <- artURL:
 		}
+	}
+	close(jobs)
+	// let any in-progress articles finish
+	wg.Wait()
 
-		// TODO: wrap in transaction...
-		// check the urls - we might already have it
-		var ids []int
-		ids, err = db.FindURLs(art.URLs)
-		if err == nil {
-			if len(ids) == 1 {
-				art.ID = ids[0]
-			}
-			if len(ids) > 1 {
-				err = fmt.Errorf("resolves to %d articles", len(ids))
-			}
-		}
+	if err == nil {
+		err = abortErr
+	}
+	return err
+}
 
-		if err == nil {
-			if art.ID != 0 && !updateMode {
-				scraper.errorLog.Printf("already got %s (id %d)\n", artURL, art.ID)
-				// TODO: add missing URLs!!!
-				continue
-			}
-			_, err = db.Stash(art)
+// incErrorCount bumps the error count (safe to call from multiple workers)
+// and returns the new count.
+func (scraper *GenericScraper) incErrorCount() int {
+	scraper.statsLock.Lock()
+	defer scraper.statsLock.Unlock()
+	scraper.stats.ErrorCount += 1
+	return scraper.stats.ErrorCount
+}
+
+// fetch, scrape and store a single article.
+func (scraper *GenericScraper) fetchAndStashOne(artURL string, db store.Store, updateMode bool) error {
+	//		scraper.infoLog.Printf("fetch/scrape %s", artURL)
+	art, err := scraper.ScrapeArt(artURL)
+	if err != nil {
+		return err
+	}
+
+	// TODO: wrap in transaction...
+	// check the urls - we might already have it
+	var ids []int
+	ids, err = db.FindURLs(art.URLs)
+	if err == nil {
+		if len(ids) == 1 {
+			art.ID = ids[0]
 		}
-		if err != nil {
-			scraper.errorLog.Printf("stash failure on: %s (on %s)\n", err, artURL)
-			scraper.stats.ErrorCount += 1
-			if scraper.stats.ErrorCount > 100+len(newArts)/10 {
-				return fmt.Errorf("too many errors (%d)", scraper.stats.ErrorCount)
+		if len(ids) > 1 {
+			err = fmt.Errorf("resolves to %d articles", len(ids))
+		}
+	}
+
+	if err == nil {
+		if art.ID != 0 && !updateMode {
+			// already got it, but maybe under other URLs
+			var added int
+			added, err = db.AddURLs(art.ID, art.URLs)
+			if err != nil {
+				return fmt.Errorf("adding urls failed: %s (on %s)", err, artURL)
 			}
-			continue
+			scraper.errorLog.Printf("already got %s (id %d, %d new urls added)\n", artURL, art.ID, added)
+			return nil
 		}
-		scraper.stats.StashCount += 1
-		scraper.infoLog.Printf("scraped %s (%d chars)\n", artURL, len(art.Content))
+		_, err = db.Stash(art)
+	}
+	if err != nil {
+		return fmt.Errorf("stash failure on: %s (on %s)", err, artURL)
 	}
+	scraper.statsLock.Lock()
+	scraper.stats.StashCount += 1
+	scraper.statsLock.Unlock()
+	scraper.infoLog.Printf("scraped %s (%d chars)\n", artURL, len(art.Content))
 	return nil
 }
 
-func (scraper *Scraper) ScrapeArt(artURL string) (*store.Article, error) {
-	// FETCH
-	fetchTime := time.Now()
-	req, err := http.NewRequest("GET", artURL, nil)
+// fromArchive looks for a usable copy of the article in the archive.
+// Returns nil if archive-first mode is off, or there's no recent enough
+// copy.
+func (scraper *GenericScraper) fromArchive(artURL string) *http.Response {
+	if !scraper.Conf.ArchiveFirst || scraper.archiveDir == "" {
+		return nil
+	}
+	resp, fetchTime, err := arc.FindResponse(scraper.archiveDir, artURL)
+	if err == arc.ErrNotArchived {
+		return nil
+	}
 	if err != nil {
-		return nil, err
+		scraper.errorLog.Printf("archive lookup failed (%s): %s\n", artURL, err)
+		return nil
 	}
-	// NOTE: FT.com always returns 403 if no Accept header is present.
-	// Seems like a reasonable thing to send anyway...
-	//req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
-	req.Header.Set("Accept", "*/*")
-	if scraper.Conf.UserAgent != "" {
-		req.Header.Set("User-Agent", scraper.Conf.UserAgent)
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil // try again
+	}
+	if scraper.archiveMaxAge > 0 && time.Since(fetchTime) > scraper.archiveMaxAge {
+		resp.Body.Close()
+		return nil // stale
 	}
+	return resp
+}
 
-	// other possible headers we might want to fiddle with:
-	//req.Header.Set("User-Agent", `Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:28.0) Gecko/20100101 Firefox/28.0`)
-	//req.Header.Set("Referrer", "http://...")
-	//req.Header.Set("Accept-Language", "en-US,en;q=0.5")
+func (scraper *GenericScraper) ScrapeArt(artURL string) (*store.Article, error) {
+	return scraper.scrapeArt(artURL, nil)
+}
 
-	resp, err := scraper.client.Do(req)
-	if err != nil {
-		return nil, err
+// scrapeArt does the work for ScrapeArt, recording the steps in tr
+// (if not nil) as it goes.
+func (scraper *GenericScraper) scrapeArt(artURL string, tr *scrapeTrace) (*store.Article, error) {
+	resp := scraper.fromArchive(artURL)
+	if resp != nil {
+		if tr != nil {
+			tr.fromArchive = true
+		}
+		scraper.statsLock.Lock()
+		scraper.stats.ArchiveCount += 1
+		scraper.statsLock.Unlock()
+	} else {
+		var err error
+		resp, err = scraper.fetchArt(artURL)
+		if err != nil {
+			return nil, err
+		}
 	}
 	defer resp.Body.Close()
 
-	// ARCHIVE
-	err = arc.ArchiveResponse(scraper.archiveDir, resp, artURL, fetchTime)
-	if err != nil {
-		return nil, err
+	// where did we end up?
+	chain := fetch.RedirectChain(resp)
+	if len(chain) == 0 || chain[0] != artURL {
+		// (older archives don't hold the redirects)
+		chain = append([]string{artURL}, chain...)
+	}
+	if tr != nil {
+		tr.status = resp.Status
+		tr.chain = chain
 	}
 
 	// EXTRACT
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("HTTP error: %s (%s)", resp.Status, artURL)
 	}
+	finalURL := chain[len(chain)-1]
+	if finalURL != artURL {
+		if _, err := scraper.CookArticleURL(finalURL); err != nil {
+			return nil, scraper.reject(artURL, rejectRedirect, fmt.Sprintf("%s: %s", finalURL, err))
+		}
+	}
 
 	rawHTML, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	scraped, err := arts.ExtractFromHTML(rawHTML, artURL)
+	scraped, err := arts.ExtractFromHTML(rawHTML, finalURL)
+	if err != nil {
+		return nil, err
+	}
+	if tr != nil {
+		extracted := *scraped
+		tr.extracted = &extracted
+	}
+	err = scraper.override.Apply(scraped, rawHTML, finalURL)
 	if err != nil {
 		return nil, err
 	}
 
 	art := store.ConvertArticle(scraped)
+	// fill in anything the extractor missed from discovery
+	if hint, got := scraper.hints[artURL]; got {
+		applyHint(art, hint)
+	}
+	// record every url we passed through on the way
+	for _, u := range chain {
+		art.AddURL(u)
+	}
+	if tr != nil {
+		tr.art = art
+	}
+
+	if reason, detail := scraper.accept.check(art, rawHTML); reason != "" {
+		return nil, scraper.reject(artURL, reason, detail)
+	}
+	if scraper.loc != nil {
+		art.ResolveTimezone(scraper.loc)
+	}
 
 	if scraper.Conf.PubCode != "" {
 		art.Publication.Code = scraper.Conf.PubCode
 	} else {
-		art.Publication.Code = scraper.Name
+		art.Publication.Code = scraper.name
 	}
 	// TODO: set publication code here!
 	return art, nil
 }
+
+// applyHint fills in any missing headline or publication date using
+// details found during discovery (eg from a feed).
+func applyHint(art *store.Article, hint discover.Hint) {
+	if strings.TrimSpace(art.Headline) == "" && hint.Title != "" {
+		art.Headline = hint.Title
+	}
+	if art.Published == "" {
+		// (naive timestamps get resolved along with the extracted ones)
+		art.Published = hint.Published
+	}
+}
+
+// reject counts a rejected article, returning a rejectError for it.
+func (scraper *GenericScraper) reject(artURL string, reason string, detail string) error {
+	scraper.statsLock.Lock()
+	defer scraper.statsLock.Unlock()
+	if scraper.stats.Rejected == nil {
+		scraper.stats.Rejected = map[string]int{}
+	}
+	scraper.stats.Rejected[reason]++
+	return &rejectError{artURL: artURL, reason: reason, detail: detail}
+}
+
+// fetchArt fetches an article over HTTP and archives the response.
+func (scraper *GenericScraper) fetchArt(artURL string) (*http.Response, error) {
+	// FETCH
+	fetchTime := time.Now()
+	req, err := http.NewRequestWithContext(scraper.ctx, "GET", artURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req, redirects := fetch.WithRedirectLog(req)
+	if scraper.robots != nil && !scraper.robots.Allowed(req.URL) {
+		scraper.statsLock.Lock()
+		scraper.stats.DisallowedCount += 1
+		scraper.statsLock.Unlock()
+		return nil, fetch.ErrDisallowed
+	}
+	// (headers are added by the client - see buildHeaders())
+	resp, res, err := scraper.retry.Do(scraper.client, req, scraper.quit)
+	if res.Retries > 0 {
+		scraper.statsLock.Lock()
+		scraper.stats.RetryCount += res.Retries
+		if res.GaveUp {
+			scraper.stats.GiveUpCount += 1
+		}
+		scraper.statsLock.Unlock()
+	}
+	if err == fetch.ErrQuit {
+		return nil, ErrQuit
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// ARCHIVE (including any redirects)
+	if scraper.archiveDir == "" || scraper.noArchive {
+		return resp, nil
+	}
+	err = arc.ArchiveResponses(scraper.archiveDir, append(redirects.Responses(), resp), artURL, fetchTime)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
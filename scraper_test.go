@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// artServer serves articles at /art/N. Paths beginning /missing/ give a 404,
+// and requests without an Accept header get a 403.
+// started receives a value as each request arrives (if there's room).
+type artServer struct {
+	*httptest.Server
+	started chan struct{}
+
+	lock        sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func newArtServer(pause time.Duration) *artServer {
+	srv := &artServer{started: make(chan struct{}, 1)}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case srv.started <- struct{}{}:
+		default:
+		}
+		srv.lock.Lock()
+		srv.inFlight++
+		if srv.inFlight > srv.maxInFlight {
+			srv.maxInFlight = srv.inFlight
+		}
+		srv.lock.Unlock()
+		defer func() {
+			srv.lock.Lock()
+			srv.inFlight--
+			srv.lock.Unlock()
+		}()
+		time.Sleep(pause)
+
+		// like FT.com, insist on an Accept header
+		if r.Header.Get("Accept") == "" {
+			http.Error(w, "no Accept header", http.StatusForbidden)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/missing/") {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `<html><head><title>Article %s</title>
+<meta property="article:published_time" content="2020-01-02T10:00:00Z" />
+</head><body><article><h1>Article %s</h1>
+<p>This is the text of article %s, which goes on for a bit so as to look
+like a real article. It has a couple of sentences in it.</p>
+</article></body></html>`, r.URL.Path, r.URL.Path, r.URL.Path)
+	}))
+	return srv
+}
+
+func newTestScraper(t *testing.T, srv *artServer, delay string, workers int) *GenericScraper {
+	conf := &ScraperConf{
+		Workers:      workers,
+		Delay:        delay,
+		Retries:      -1,
+		IgnoreRobots: true,
+	}
+	conf.URL = srv.URL + "/"
+	conf.ArtPat = []string{`/art/`}
+	scraper, err := NewGenericScraper("test", conf, 0, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scraper
+}
+
+func TestFetchAndStash(t *testing.T) {
+	srv := newArtServer(20 * time.Millisecond)
+	defer srv.Close()
+
+	arts := []string{}
+	for i := 0; i < 12; i++ {
+		arts = append(arts, fmt.Sprintf("%s/art/%d", srv.URL, i))
+	}
+	arts = append(arts, srv.URL+"/missing/1", srv.URL+"/missing/2")
+
+	scraper := newTestScraper(t, srv, "0", 4)
+	err := scraper.FetchAndStash(arts, newJSONStore(ioutil.Discard), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := scraper.stats
+	if stats.StashCount != 12 || stats.ErrorCount != 2 {
+		t.Errorf("expected 12 stashed, 2 errors, got %d stashed, %d errors", stats.StashCount, stats.ErrorCount)
+	}
+	if srv.maxInFlight < 2 {
+		t.Errorf("expected parallel fetching, but max requests in flight was %d", srv.maxInFlight)
+	}
+}
+
+func TestFetchAndStashStop(t *testing.T) {
+	srv := newArtServer(0)
+	defer srv.Close()
+
+	arts := []string{}
+	for i := 0; i < 20; i++ {
+		arts = append(arts, fmt.Sprintf("%s/art/%d", srv.URL, i))
+	}
+
+	// with a long politeness delay, all but the first fetch will be
+	// stuck waiting when Stop() is called
+	scraper := newTestScraper(t, srv, "1h", 3)
+	done := make(chan error)
+	go func() {
+		done <- scraper.FetchAndStash(arts, newJSONStore(ioutil.Discard), false)
+	}()
+
+	<-srv.started
+	scraper.Stop()
+	select {
+	case err := <-done:
+		if err != ErrQuit {
+			t.Errorf("expected ErrQuit, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("FetchAndStash didn't stop")
+	}
+	if scraper.stats.ErrorCount != 0 {
+		t.Errorf("expected no errors from abandoned fetches, got %d", scraper.stats.ErrorCount)
+	}
+}
@@ -1,105 +1,113 @@
-package main
-
-/*
-TODO:
-- specify date range of interest
-- skip index files which look like they're outside the date range (often
-  they'll have a date in the filename)
-- handle gzip files
-- read robots.txt to get sitemap files
-- add support for googlenews extra fields
-- factor out for use as a discovery mechanism in scrapeomat
-*/
+// Package sitemap reads sitemap files (https://www.sitemaps.org), for
+// finding article links without crawling a site.
+//
+// It handles sitemap indexes, gzipped sitemaps, date filtering (by
+// lastmod) and the Google News extensions.
+package sitemap
 
 import (
-	//	"encoding/csv"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/xml"
-	"flag"
 	"fmt"
-	"github.com/bcampbell/arts/util"
-	"net/http"
-	"os"
-	"strings"
+	"io"
+	"time"
 )
 
+// URL is an entry in a <urlset>.
 type URL struct {
 	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod"`
 	ChangeFreq string `xml:"changefreq"`
 	Priority   string `xml:"priority"`
-	LastMod    string `xml:"lastmod"`
+	// News holds the Google News extensions, if present
+	News *News `xml:"news"`
 }
 
-type URLSet struct {
-	URLs []URL `xml:"url"`
+// News holds the Google News extensions to a sitemap URL
+// (https://developers.google.com/search/docs/crawling-indexing/sitemaps/news-sitemap).
+type News struct {
+	PublicationName     string `xml:"publication>name"`
+	PublicationLanguage string `xml:"publication>language"`
+	PublicationDate     string `xml:"publication_date"`
+	Title               string `xml:"title"`
+	Keywords            string `xml:"keywords"`
+}
+
+// Date returns the best date for the URL - the news publication date if
+// there is one, otherwise lastmod. Returns a zero time if there's no
+// usable date.
+func (u *URL) Date() time.Time {
+	if u.News != nil && u.News.PublicationDate != "" {
+		if t, err := ParseDate(u.News.PublicationDate); err == nil {
+			return t
+		}
+	}
+	if u.LastMod != "" {
+		if t, err := ParseDate(u.LastMod); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
 }
 
+// Sitemap is an entry in a <sitemapindex>.
 type Sitemap struct {
 	Loc     string `xml:"loc"`
 	LastMod string `xml:"lastmod"`
 }
-type SitemapIndex struct {
-	Sitemaps []Sitemap `xml:"sitemap"`
-}
 
-type Result struct {
+// File is a parsed sitemap file. It might be a <urlset> or a
+// <sitemapindex> (or, in the wild, a mix).
+type File struct {
 	XMLName  xml.Name
-	Sitemaps []Sitemap `xml:"sitemap"`
 	URLs     []URL     `xml:"url"`
-	/*URLSet       []URLSet       `xml:"urlset"`
-	itemapIndex []SitemapIndex `xml:"sitemapindex"`
-	*/
+	Sitemaps []Sitemap `xml:"sitemap"`
 }
 
-func main() {
-	flag.Parse()
-	for _, sitemapURL := range flag.Args() {
-		urls, err := doit(sitemapURL, "")
+// Parse reads a sitemap file, uncompressing it first if it's gzipped.
+func Parse(in io.Reader) (*File, error) {
+	r := bufio.NewReader(in)
+	// some sites serve sitemap.xml.gz files verbatim (ie not using
+	// Content-Encoding), so check for the gzip magic number.
+	magic, _ := r.Peek(2)
+	var src io.Reader = r
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		dec, err := gzip.NewReader(r)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
-		}
-		for _, u := range urls {
-			fmt.Println(u.LastMod, u.Loc)
+			return nil, fmt.Errorf("gunzip failed: %s", err)
 		}
-	}
-}
-
-func doit(sitemapURL string, indent string) ([]URL, error) {
-	result := make([]URL, 0)
-	politeClient := &http.Client{
-		Transport: util.NewPoliteTripper(),
+		defer dec.Close()
+		src = dec
 	}
 
-	resp, err := politeClient.Get(sitemapURL)
+	f := &File{}
+	err := xml.NewDecoder(src).Decode(f)
 	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("%s%s: http error %d", indent, sitemapURL, resp.StatusCode)
+		return nil, fmt.Errorf("decode failed: %s", err)
 	}
+	return f, nil
+}
 
-	dat := Result{}
-	dec := xml.NewDecoder(resp.Body)
-	err = dec.Decode(&dat)
-	if err != nil {
-		return nil, err
+// ParseDate parses a sitemap timestamp (W3C datetime, in any of the
+// commonly-seen levels of precision).
+func ParseDate(s string) (time.Time, error) {
+	var t time.Time
+	var err error
+	fmts := []string{time.RFC3339,
+		"2006-01-02T15:04:05Z0700", // eg 2021-04-30T18:10:59Z
+		"2006-01-02T15:04Z07:00",   // eg 2021-04-30T18:10+01:00
+		"2006-01-02T15:04Z0700",    // eg 2021-04-30T18:10Z
+		"2006-01-02",
+		"2006-01",
+		"2006",
 	}
-	resp.Body.Close()
-
-	result = append(result, dat.URLs...)
-	for _, sitemap := range dat.Sitemaps {
-
-		if !strings.Contains(sitemap.LastMod, "2014") {
-			fmt.Fprintf(os.Stderr, "%sSKIP %s (%s)\n", indent, sitemap.Loc, sitemap.LastMod)
-			continue
-		}
-		foo, err := doit(sitemap.Loc, indent+"  ")
-		if err != nil {
-			return nil, err
+	for _, layout := range fmts {
+		t, err = time.Parse(layout, s)
+		if err == nil {
+			return t, nil
 		}
-		fmt.Fprintf(os.Stderr, "%s%s (%s) => %d urls\n", indent, sitemap.Loc, sitemap.LastMod, len(foo))
-		result = append(result, foo...)
 	}
-	return result, nil
+	return t, err
 }
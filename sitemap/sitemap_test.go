@@ -0,0 +1,270 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bcampbell/scrapeomat/fetch"
+)
+
+const testIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<sitemap><loc>{{SRV}}/news.xml</loc><lastmod>2021-05-02</lastmod></sitemap>
+<sitemap><loc>{{SRV}}/old.xml.gz</loc><lastmod>2014-01-01T00:00:00Z</lastmod></sitemap>
+<sitemap><loc>{{SRV}}/missing.xml</loc></sitemap>
+</sitemapindex>`
+
+const testNews = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"
+        xmlns:news="http://www.google.com/schemas/sitemap-news/0.9">
+<url>
+  <loc>http://example.com/news/moon-cheese</loc>
+  <news:news>
+    <news:publication><news:name>Example News</news:name><news:language>en</news:language></news:publication>
+    <news:publication_date>2021-05-01T10:30:00+01:00</news:publication_date>
+    <news:title>Moon made of cheese</news:title>
+  </news:news>
+</url>
+<url><loc>http://example.com/news/undated</loc></url>
+<url><loc>http://example.com/news/ancient</loc><lastmod>2001-01-01</lastmod></url>
+</urlset>`
+
+const testOld = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>http://example.com/news/old</loc><lastmod>2014-01-01</lastmod></url>
+</urlset>`
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	f, err := Parse(strings.NewReader(testNews))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.URLs) != 3 {
+		t.Fatalf("expected 3 urls, got %d", len(f.URLs))
+	}
+	news := f.URLs[0].News
+	if news == nil || news.PublicationName != "Example News" || news.Title != "Moon made of cheese" {
+		t.Errorf("news: got %+v", news)
+	}
+	expect := time.Date(2021, 5, 1, 9, 30, 0, 0, time.UTC)
+	if got := f.URLs[0].Date(); !got.Equal(expect) {
+		t.Errorf("date: expected %s, got %s", expect, got)
+	}
+	if got := f.URLs[1].Date(); !got.IsZero() {
+		t.Errorf("date: expected none, got %s", got)
+	}
+
+	// gzipped
+	f, err = Parse(bytes.NewReader(gzipped(testOld)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.URLs) != 1 || f.URLs[0].Loc != "http://example.com/news/old" {
+		t.Errorf("gzipped: got %+v", f.URLs)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			w.Write([]byte(strings.Replace(testIndex, "{{SRV}}", srv.URL, -1)))
+		case "/news.xml":
+			w.Write([]byte(testNews))
+		case "/old.xml.gz":
+			w.Header().Set("Content-Type", "application/x-gzip")
+			w.Write(gzipped(testOld))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	locs := func(urls []URL) string {
+		out := []string{}
+		for _, u := range urls {
+			out = append(out, strings.TrimPrefix(u.Loc, "http://example.com/news/"))
+		}
+		return strings.Join(out, ",")
+	}
+
+	w := NewWalker(srv.Client())
+	urls, err := w.Walk([]string{srv.URL + "/sitemap.xml"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := locs(urls); got != "moon-cheese,undated,ancient,old" {
+		t.Errorf("no window: got %s", got)
+	}
+	if w.Stats.FetchCount != 3 || w.Stats.ErrorCount != 1 {
+		t.Errorf("stats: got %+v", w.Stats)
+	}
+
+	// date window, applied to the index too
+	w.From = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.FilterIndexes = true
+	urls, err = w.Walk([]string{srv.URL + "/sitemap.xml"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := locs(urls); got != "moon-cheese,undated" {
+		t.Errorf("window: got %s", got)
+	}
+	if w.Stats.SkippedCount != 1 || w.Stats.RejectedCount != 1 {
+		t.Errorf("window stats: got %+v", w.Stats)
+	}
+
+	// give up on errors
+	w.MaxErrors = 1
+	_, err = w.Walk([]string{srv.URL + "/nope.xml", srv.URL + "/nope2.xml"}, nil)
+	if err != ErrTooManyErrors {
+		t.Errorf("expected ErrTooManyErrors, got %v", err)
+	}
+}
+
+func TestWalkLocations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemaptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localFile := filepath.Join(dir, "local.xml")
+	err = ioutil.WriteFile(localFile, []byte(testOld), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemaps/index.xml":
+			// a relative sitemap, and a local file which must not be read
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<sitemap><loc>news.xml</loc></sitemap>
+<sitemap><loc>file://` + localFile + `</loc></sitemap>
+</sitemapindex>`))
+		case "/sitemaps/news.xml":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>/news/relative</loc></url>
+</urlset>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	w := NewWalker(srv.Client())
+	urls, err := w.Walk([]string{srv.URL + "/sitemaps/index.xml"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0].Loc != srv.URL+"/news/relative" {
+		t.Errorf("expected just %s/news/relative, got %+v", srv.URL, urls)
+	}
+	if w.Stats.FetchCount != 2 || w.Stats.ErrorCount != 1 {
+		t.Errorf("stats: got %+v", w.Stats)
+	}
+
+	// local files only allowed at the top level, if AllowLocal is set
+	urls, err = w.Walk([]string{localFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 0 || w.Stats.ErrorCount != 1 {
+		t.Errorf("local without AllowLocal: got %+v (%+v)", urls, w.Stats)
+	}
+	w.AllowLocal = true
+	urls, err = w.Walk([]string{localFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 {
+		t.Errorf("local with AllowLocal: got %+v (%+v)", urls, w.Stats)
+	}
+}
+
+func TestWalkRetries(t *testing.T) {
+	flakyCnt := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky.xml":
+			// fails once, then works
+			flakyCnt++
+			if flakyCnt == 1 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(testOld))
+		default:
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	w := NewWalker(srv.Client())
+	w.Retry = fetch.Policy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	urls, err := w.Walk([]string{srv.URL + "/flaky.xml", srv.URL + "/down.xml"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 {
+		t.Errorf("expected 1 url, got %+v", urls)
+	}
+	// 1 retry for flaky.xml, 2 for down.xml (which then gives up)
+	if w.Stats.RetryCount != 3 || w.Stats.GiveUpCount != 1 || w.Stats.ErrorCount != 1 {
+		t.Errorf("stats: got %+v", w.Stats)
+	}
+}
+
+func TestWalkRobots(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Write([]byte("User-agent: *\nDisallow: /old\n"))
+		case "/sitemap.xml":
+			w.Write([]byte(strings.Replace(testIndex, "{{SRV}}", srv.URL, -1)))
+		case "/news.xml":
+			w.Write([]byte(testNews))
+		case "/old.xml.gz":
+			t.Errorf("fetched disallowed sitemap")
+			w.Header().Set("Content-Type", "application/x-gzip")
+			w.Write(gzipped(testOld))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	w := NewWalker(srv.Client())
+	w.Robots = fetch.NewRobotsCache(srv.Client(), "")
+	urls, err := w.Walk([]string{srv.URL + "/sitemap.xml", srv.URL + "/old.xml.gz"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 3 {
+		t.Errorf("expected 3 urls, got %+v", urls)
+	}
+	// old.xml.gz is skipped (whether given directly or via the index)
+	if w.Stats.FetchCount != 2 || w.Stats.DisallowedCount != 1 || w.Stats.ErrorCount != 1 {
+		t.Errorf("stats: got %+v", w.Stats)
+	}
+}
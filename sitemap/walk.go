@@ -0,0 +1,260 @@
+package sitemap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bcampbell/scrapeomat/fetch"
+)
+
+// Logger is the logging interface used by this package.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type nullLogger struct{}
+
+func (l nullLogger) Printf(format string, v ...interface{}) {}
+
+// ErrTooManyErrors is returned by Walk if MaxErrors is exceeded.
+var ErrTooManyErrors = errors.New("too many errors")
+
+// Stats counts what happened during a Walk.
+type Stats struct {
+	// FetchCount is the number of sitemap files fetched
+	FetchCount int
+	// ErrorCount is the number of sitemap files which couldn't be fetched
+	// or parsed
+	ErrorCount int
+	// SkippedCount is the number of <sitemap> entries not followed,
+	// because their lastmod is outside the date window
+	SkippedCount int
+	// RejectedCount is the number of <url> entries outside the date window
+	RejectedCount int
+	// DisallowedCount is the number of sitemap files skipped because
+	// robots.txt forbids fetching them
+	DisallowedCount int
+	// RetryCount is the number of retries made for transient HTTP
+	// failures, GiveUpCount the number of files still failing after all
+	// retries.
+	RetryCount  int
+	GiveUpCount int
+}
+
+// Walker fetches sitemap files, following sitemap indexes to find all
+// the URLs.
+type Walker struct {
+	Client    *http.Client
+	UserAgent string
+	// Retry is the policy for retrying transient HTTP failures (the zero
+	// value means no retries)
+	Retry fetch.Policy
+	// From and To give a date window (inclusive From, exclusive To) for
+	// URLs. Zero means no limit. URLs without dates are always accepted.
+	From time.Time
+	To   time.Time
+	// FilterIndexes applies the date window to the lastmod of <sitemap>
+	// entries too, to skip whole files which are too old (or new).
+	FilterIndexes bool
+	// NoFollow means <sitemap> entries aren't followed.
+	NoFollow bool
+	// MaxErrors is the number of bad sitemap files to put up with before
+	// giving up (0 = no limit)
+	MaxErrors int
+	// AllowLocal lets the sitemaps passed to Walk be local filenames.
+	// Sitemaps found by following sitemap indexes must always be http(s).
+	AllowLocal bool
+	// Robots, if set, is used to skip sitemap files which robots.txt
+	// forbids fetching (the URLs inside them aren't checked).
+	Robots *fetch.RobotsCache
+
+	ErrorLog Logger
+	InfoLog  Logger
+	Stats    Stats
+}
+
+// NewWalker creates a Walker using client to make requests.
+func NewWalker(client *http.Client) *Walker {
+	return &Walker{
+		Client:   client,
+		ErrorLog: nullLogger{},
+		InfoLog:  nullLogger{},
+	}
+}
+
+// a sitemap file waiting to be fetched
+type queued struct {
+	loc   string
+	local bool // can it be a local file?
+}
+
+// Walk fetches the given sitemap files (URLs, or local filenames if
+// AllowLocal is set) and any sitemaps they reference, returning the URLs
+// inside the date window.
+// Relative locations in the sitemaps are resolved against the sitemap
+// they're in.
+// A quit request returns fetch.ErrQuit.
+func (w *Walker) Walk(sitemapURLs []string, quit <-chan struct{}) ([]URL, error) {
+	w.Stats = Stats{}
+	// for abandoning requests in progress if quit is closed
+	ctx, cancel := fetch.QuitContext(quit)
+	defer cancel()
+	out := []URL{}
+	seen := map[string]bool{}
+	queue := []queued{}
+	for _, loc := range sitemapURLs {
+		queue = append(queue, queued{loc: loc, local: w.AllowLocal})
+	}
+	for len(queue) > 0 {
+		if quit != nil {
+			select {
+			case <-quit:
+				return nil, fetch.ErrQuit
+			default:
+			}
+		}
+		loc := queue[0].loc
+		local := queue[0].local
+		queue = queue[1:]
+		if seen[loc] {
+			continue
+		}
+		seen[loc] = true
+
+		base, f, err := w.get(ctx, loc, local, quit)
+		if err == fetch.ErrQuit {
+			return nil, err
+		}
+		if err == fetch.ErrDisallowed {
+			w.InfoLog.Printf("skipping sitemap %s (disallowed by robots.txt)\n", loc)
+			w.Stats.DisallowedCount++
+			continue
+		}
+		if err != nil {
+			w.ErrorLog.Printf("sitemap %s: %s\n", loc, err)
+			w.Stats.ErrorCount++
+			if w.MaxErrors > 0 && w.Stats.ErrorCount > w.MaxErrors {
+				return nil, ErrTooManyErrors
+			}
+			continue
+		}
+		w.Stats.FetchCount++
+
+		accepted := 0
+		for _, u := range f.URLs {
+			if base != nil {
+				u.Loc = resolve(base, u.Loc)
+			}
+			if !w.inWindow(u.Date()) {
+				w.Stats.RejectedCount++
+				continue
+			}
+			out = append(out, u)
+			accepted++
+		}
+
+		followed := 0
+		if !w.NoFollow {
+			for _, sm := range f.Sitemaps {
+				if w.FilterIndexes && sm.LastMod != "" {
+					t, err := ParseDate(sm.LastMod)
+					if err == nil && !w.inWindow(t) {
+						w.InfoLog.Printf("skipping sitemap %s (lastmod %s)\n", sm.Loc, sm.LastMod)
+						w.Stats.SkippedCount++
+						continue
+					}
+				}
+				smLoc := sm.Loc
+				if base != nil {
+					smLoc = resolve(base, smLoc)
+				}
+				// (followed sitemaps are never local files)
+				queue = append(queue, queued{loc: smLoc})
+				followed++
+			}
+		}
+		w.InfoLog.Printf("sitemap %s: %d urls (%d accepted), %d sitemaps (%d followed)\n",
+			loc, len(f.URLs), accepted, len(f.Sitemaps), followed)
+	}
+	return out, nil
+}
+
+// inWindow returns true if t is in the date window (or zero)
+func (w *Walker) inWindow(t time.Time) bool {
+	if t.IsZero() {
+		return true
+	}
+	if !w.From.IsZero() && t.Before(w.From) {
+		return false
+	}
+	if !w.To.IsZero() && !t.Before(w.To) {
+		return false
+	}
+	return true
+}
+
+// resolve a (possibly relative) location in a sitemap
+func resolve(base *url.URL, loc string) string {
+	u, err := base.Parse(strings.TrimSpace(loc))
+	if err != nil {
+		return loc // leave it for someone else to reject
+	}
+	return u.String()
+}
+
+// get fetches and parses a single sitemap file. local says if loc can be
+// a local file.
+// Returns the URL of the file (nil for a local file), for resolving
+// relative locations.
+func (w *Walker) get(ctx context.Context, loc string, local bool, quit <-chan struct{}) (*url.URL, *File, error) {
+	u, err := url.Parse(loc)
+	if err != nil {
+		return nil, nil, err
+	}
+	var in io.ReadCloser
+	switch {
+	case u.Scheme == "http" || u.Scheme == "https":
+		if w.Robots != nil && !w.Robots.Allowed(u) {
+			return nil, nil, fetch.ErrDisallowed
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", loc, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Accept", "*/*")
+		if w.UserAgent != "" {
+			req.Header.Set("User-Agent", w.UserAgent)
+		}
+		resp, res, err := w.Retry.Do(w.Client, req, quit)
+		w.Stats.RetryCount += res.Retries
+		if res.GaveUp {
+			w.Stats.GiveUpCount++
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			resp.Body.Close()
+			return nil, nil, fmt.Errorf("HTTP code %d", resp.StatusCode)
+		}
+		in = resp.Body
+	case local && (u.Scheme == "" || u.Scheme == "file"):
+		in, err = os.Open(u.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		u = nil
+	default:
+		return nil, nil, fmt.Errorf("not an http(s) url")
+	}
+	defer in.Close()
+	f, err := Parse(in)
+	return u, f, err
+}
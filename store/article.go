@@ -4,6 +4,7 @@ import (
 	// TODO: KILLKILLKILL
 	"github.com/bcampbell/arts/arts"
 	"strings"
+	"time"
 )
 
 type Author struct {
@@ -98,3 +99,41 @@ func ConvertArticle(src *arts.Article) *Article {
 
 	return art
 }
+
+// timestamp formats which lack a timezone
+var naiveTimeFmts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04",
+}
+
+// ResolveTime interprets a timestamp which has no timezone as being in loc,
+// returning it in RFC3339 form.
+// Anything else (timestamps with a timezone, plain dates etc) is returned
+// unchanged.
+func ResolveTime(timestamp string, loc *time.Location) string {
+	for _, layout := range naiveTimeFmts {
+		t, err := time.ParseInLocation(layout, timestamp, loc)
+		if err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	return timestamp
+}
+
+// ResolveTimezone applies ResolveTime to the article dates.
+func (art *Article) ResolveTimezone(loc *time.Location) {
+	art.Published = ResolveTime(art.Published, loc)
+	art.Updated = ResolveTime(art.Updated, loc)
+}
+
+// AddURL adds a URL to the article, if it's not already there.
+func (art *Article) AddURL(u string) {
+	for _, existing := range art.URLs {
+		if existing == u {
+			return
+		}
+	}
+	art.URLs = append(art.URLs, u)
+}
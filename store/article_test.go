@@ -0,0 +1,35 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveTime(t *testing.T) {
+	mx, err := time.LoadLocation("America/Mexico_City")
+	if err != nil {
+		t.Skip("no timezone data")
+	}
+	testData := []struct {
+		in     string
+		expect string
+	}{
+		{"2017-01-05T10:00", "2017-01-05T10:00:00-06:00"},
+		{"2017-01-05T10:00:30", "2017-01-05T10:00:30-06:00"},
+		{"2017-01-05 10:00:30", "2017-01-05T10:00:30-06:00"},
+		{"2017-01-05T10:00:30.123", "2017-01-05T10:00:30-06:00"},
+		// daylight saving
+		{"2017-07-05T10:00", "2017-07-05T10:00:00-05:00"},
+		// already got a timezone, or not a timestamp - leave alone
+		{"2017-01-05T10:00:00Z", "2017-01-05T10:00:00Z"},
+		{"2017-01-05T10:00:00+01:00", "2017-01-05T10:00:00+01:00"},
+		{"2017-01-05", "2017-01-05"},
+		{"", ""},
+	}
+	for _, dat := range testData {
+		got := ResolveTime(dat.in, mx)
+		if got != dat.expect {
+			t.Errorf("ResolveTime(%q): expected %q, got %q", dat.in, dat.expect, got)
+		}
+	}
+}
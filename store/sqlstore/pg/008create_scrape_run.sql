@@ -0,0 +1,17 @@
+BEGIN;
+CREATE TABLE scrape_run (
+    id SERIAL PRIMARY KEY,
+    scraper TEXT NOT NULL,
+    started TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    pages_fetched INT NOT NULL DEFAULT 0,
+    page_errors INT NOT NULL DEFAULT 0,
+    arts_found INT NOT NULL DEFAULT 0,
+    arts_new INT NOT NULL DEFAULT 0,
+    arts_stashed INT NOT NULL DEFAULT 0,
+    art_errors INT NOT NULL DEFAULT 0,
+    abort_reason TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX ON scrape_run(scraper,started);
+INSERT INTO version (ver) VALUES (8);
+COMMIT;
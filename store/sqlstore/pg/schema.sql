@@ -4,6 +4,7 @@ DROP TABLE IF EXISTS author_attr CASCADE;
 DROP TABLE IF EXISTS article CASCADE;
 DROP TABLE IF EXISTS author CASCADE;
 DROP TABLE IF EXISTS publication CASCADE;
+DROP TABLE IF EXISTS scrape_run;
 DROP TABLE IF EXISTS version;
 DROP TABLE IF EXISTS settings;
 
@@ -69,7 +70,22 @@ CREATE INDEX ON article_keyword(id);
 CREATE INDEX ON article_keyword(article_id);
 CREATE INDEX ON article_keyword(name);
 
+CREATE TABLE scrape_run (
+    id SERIAL PRIMARY KEY,
+    scraper TEXT NOT NULL,
+    started TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    pages_fetched INT NOT NULL DEFAULT 0,
+    page_errors INT NOT NULL DEFAULT 0,
+    arts_found INT NOT NULL DEFAULT 0,
+    arts_new INT NOT NULL DEFAULT 0,
+    arts_stashed INT NOT NULL DEFAULT 0,
+    art_errors INT NOT NULL DEFAULT 0,
+    abort_reason TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX ON scrape_run(scraper,started);
+
 CREATE TABLE version (ver INTEGER NOT NULL);
 CREATE TABLE settings (n TEXT, v TEXT NOT NULL);
-INSERT INTO version (ver) VALUES (7);
+INSERT INTO version (ver) VALUES (8);
 
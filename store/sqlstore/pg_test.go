@@ -72,6 +72,10 @@ func TestPostgres(t *testing.T) {
 		if err != nil {
 			t.Fatal(err.Error())
 		}
+		_, err = db.Exec("DELETE FROM scrape_run")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
 		ss.Close()
 	}()
 
@@ -0,0 +1,119 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bcampbell/scrapeomat/store"
+)
+
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{Valid: false}
+	}
+	return sql.NullTime{Time: t, Valid: true}
+}
+
+// StashRun records the details of a scraper run.
+// If the run has an ID, the existing record is updated, otherwise a new
+// one is added.
+// Returns the ID of the run record.
+func (ss *SQLStore) StashRun(run *store.ScrapeRun) (int, error) {
+	if run.ID != 0 {
+		q := `UPDATE scrape_run SET (scraper,started,finished,pages_fetched,page_errors,arts_found,arts_new,arts_stashed,art_errors,abort_reason) = (?,?,?,?,?,?,?,?,?,?) WHERE id=?`
+		_, err := ss.db.Exec(ss.rebind(q),
+			run.Scraper,
+			run.Started,
+			nullTime(run.Finished),
+			run.PagesFetched,
+			run.PageErrors,
+			run.ArtsFound,
+			run.ArtsNew,
+			run.ArtsStashed,
+			run.ArtErrors,
+			run.AbortReason,
+			run.ID)
+		if err != nil {
+			return 0, err
+		}
+		return run.ID, nil
+	}
+
+	q := `INSERT INTO scrape_run(scraper,started,finished,pages_fetched,page_errors,arts_found,arts_new,arts_stashed,art_errors,abort_reason) VALUES(?,?,?,?,?,?,?,?,?,?)`
+	params := []interface{}{
+		run.Scraper,
+		run.Started,
+		nullTime(run.Finished),
+		run.PagesFetched,
+		run.PageErrors,
+		run.ArtsFound,
+		run.ArtsNew,
+		run.ArtsStashed,
+		run.ArtErrors,
+		run.AbortReason,
+	}
+	switch ss.insertIDType() {
+	case RESULT:
+		result, err := ss.db.Exec(ss.rebind(q), params...)
+		if err != nil {
+			return 0, err
+		}
+		runID, err := result.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		return int(runID), nil
+	case RETURNING:
+		var runID int
+		err := ss.db.QueryRow(ss.rebind(q+` RETURNING id`), params...).Scan(&runID)
+		if err != nil {
+			return 0, err
+		}
+		return runID, nil
+	default:
+		return 0, errors.New("unsupported db driver")
+	}
+}
+
+// FetchRuns returns the most recent runs for a scraper, newest first.
+// If scraper is empty, runs for all scrapers are returned.
+// A count of 0 means no limit.
+func (ss *SQLStore) FetchRuns(scraper string, count int) ([]store.ScrapeRun, error) {
+	q := `SELECT id,scraper,started,finished,pages_fetched,page_errors,arts_found,arts_new,arts_stashed,art_errors,abort_reason FROM scrape_run`
+	params := []interface{}{}
+	if scraper != "" {
+		q += ` WHERE scraper=?`
+		params = append(params, scraper)
+	}
+	q += ` ORDER BY started DESC, id DESC`
+	if count > 0 {
+		q += fmt.Sprintf(" LIMIT %d", count)
+	}
+
+	rows, err := ss.db.Query(ss.rebind(q), params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []store.ScrapeRun{}
+	for rows.Next() {
+		var run store.ScrapeRun
+		var finished sql.NullTime
+		err := rows.Scan(&run.ID, &run.Scraper, &run.Started, &finished,
+			&run.PagesFetched, &run.PageErrors, &run.ArtsFound, &run.ArtsNew,
+			&run.ArtsStashed, &run.ArtErrors, &run.AbortReason)
+		if err != nil {
+			return nil, err
+		}
+		if finished.Valid {
+			run.Finished = finished.Time
+		}
+		out = append(out, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -5,6 +5,43 @@ import (
 	"fmt"
 )
 
+// the schema version this code expects
+const currentSchemaVersion = 8
+
+// schema upgrades for sqlite, indexed by the version they upgrade from.
+// (postgresql upgrades are applied by hand, using the scripts in pg/)
+var sqliteUpgrades = map[int][]string{
+	7: {
+		`CREATE TABLE scrape_run (
+			id INTEGER PRIMARY KEY,
+			scraper TEXT NOT NULL,
+			started TIMESTAMP NOT NULL,
+			finished TIMESTAMP DEFAULT NULL,
+			pages_fetched INTEGER NOT NULL DEFAULT 0,
+			page_errors INTEGER NOT NULL DEFAULT 0,
+			arts_found INTEGER NOT NULL DEFAULT 0,
+			arts_new INTEGER NOT NULL DEFAULT 0,
+			arts_stashed INTEGER NOT NULL DEFAULT 0,
+			art_errors INTEGER NOT NULL DEFAULT 0,
+			abort_reason TEXT NOT NULL DEFAULT '')`,
+		`CREATE INDEX scrape_run_scraper_started ON scrape_run(scraper,started)`,
+		`INSERT INTO version (ver) VALUES (8)`,
+	},
+}
+
+// requireSchema checks there's a schema, without changing anything.
+// An out-of-date one is accepted.
+func (ss *SQLStore) requireSchema() error {
+	ver, err := ss.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if ver == 0 {
+		return fmt.Errorf("Missing Schema.")
+	}
+	return nil
+}
+
 func (ss *SQLStore) checkSchema() error {
 
 	ver, err := ss.schemaVersion()
@@ -12,21 +49,46 @@ func (ss *SQLStore) checkSchema() error {
 		return err
 	}
 	ss.DebugLog.Printf("Existing schema version: %d\n", ver)
-	if ver == 7 {
+	if ver == currentSchemaVersion {
 		return nil // up to date.
 	}
 
 	// auto schema management currently only for sqlite.
 	if ss.driverName != "sqlite3" {
-		return fmt.Errorf("Missing Schema.")
+		if ver == 0 {
+			return fmt.Errorf("Missing Schema.")
+		}
+		return fmt.Errorf("Schema out of date (ver %d, need %d) - apply upgrade scripts from store/sqlstore/pg", ver, currentSchemaVersion)
 	}
 
-	if ver != 0 {
-		return fmt.Errorf("No Schema upgrade path (from ver %d)", ver)
+	if ver == 0 {
+		err = ss.createSchema()
+		if err != nil {
+			return err
+		}
+		ver = 7
 	}
 
-	// TODO: handle schema upgrades for data-in-the-wild!
+	for ver < currentSchemaVersion {
+		stmts, got := sqliteUpgrades[ver]
+		if !got {
+			return fmt.Errorf("No Schema upgrade path (from ver %d)", ver)
+		}
+		ss.DebugLog.Printf("Upgrading schema from version %d\n", ver)
+		err = ss.execStmts(stmts)
+		if err != nil {
+			return err
+		}
+		ver, err = ss.schemaVersion()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// create the base (version 7) schema from scratch
+func (ss *SQLStore) createSchema() error {
 	stmts := []string{
 		`CREATE TABLE publication (
 			id INTEGER PRIMARY KEY,
@@ -91,7 +153,10 @@ func (ss *SQLStore) checkSchema() error {
 
 		`INSERT INTO version (ver) VALUES (7)`,
 	}
+	return ss.execStmts(stmts)
+}
 
+func (ss *SQLStore) execStmts(stmts []string) error {
 	for _, stmt := range stmts {
 		_, err := ss.db.Exec(stmt)
 		//ss.DebugLog.Printf("%s (%v)\n", stmt, err)
@@ -58,19 +58,34 @@ func New(driver string, connStr string) (*SQLStore, error) {
 	return NewFromDB(driver, db)
 }
 
+// NewExisting is like New(), but for a database which already has a
+// schema. The schema is never created or upgraded, so nothing is written
+// (eg for tools which just want to look things up).
+func NewExisting(driver string, connStr string) (*SQLStore, error) {
+	db, err := sql.Open(driver, connStr)
+	if err != nil {
+		return nil, err
+	}
+	return newFromDB(driver, db, false)
+}
+
 func NewFromDB(driver string, db *sql.DB) (*SQLStore, error) {
+	return newFromDB(driver, db, true)
+}
+
+// newFromDB sets up a SQLStore, creating or upgrading the schema (sqlite
+// only) if manageSchema is set.
+func newFromDB(driver string, db *sql.DB, manageSchema bool) (*SQLStore, error) {
 	err := db.Ping()
 	if err != nil {
 		db.Close()
 		return nil, err
 	}
 
-	// our assumed location for publication dates, when no timezone given
-	// TODO: this is the wrong place for it. Scraper should handle this on a per-publication basis
-	//	loc, err := time.LoadLocation("Europe/London")
-	//	if err != nil {
-	//		return nil, err
-	//	}
+	// our assumed location for publication dates, when no timezone given.
+	// Scrapers (and loadtool/rescrape) should already have resolved any
+	// naive timestamps on a per-publication basis (see
+	// store.ResolveTime()), so this is just a last resort.
 
 	ss := SQLStore{
 		db:         db,
@@ -81,7 +96,11 @@ func NewFromDB(driver string, db *sql.DB) (*SQLStore, error) {
 	}
 
 	// TODO: would be nice to have logger set up before here...
-	err = ss.checkSchema()
+	if manageSchema {
+		err = ss.checkSchema()
+	} else {
+		err = ss.requireSchema()
+	}
 	if err != nil {
 		db.Close()
 		return nil, err
@@ -62,6 +62,36 @@ func performDBTests(t *testing.T, ss *SQLStore) {
 	//
 	checkArticles(t, ss, testArts)
 
+	// Add some extra URLs to an existing article
+	extraURLs := []string{"http://example.com/blah-blah", "http://example.com/amp/blah-blah"}
+	added, err := ss.AddURLs(testArts[1].ID, extraURLs)
+	if err != nil {
+		t.Fatalf("AddURLs failed: %s", err)
+	}
+	if added != 2 {
+		t.Fatalf("AddURLs: added %d urls, expected 2", added)
+	}
+	// again - should be no-op
+	added, err = ss.AddURLs(testArts[1].ID, extraURLs)
+	if err != nil {
+		t.Fatalf("AddURLs failed: %s", err)
+	}
+	if added != 0 {
+		t.Fatalf("AddURLs: added %d urls, expected 0", added)
+	}
+	testArts[1].URLs = extraURLs
+	checkArticles(t, ss, testArts)
+	newURLs, err := ss.WhichAreNew(extraURLs)
+	if err != nil {
+		t.Fatalf("WhichAreNew failed: %s", err)
+	}
+	if len(newURLs) != 0 {
+		t.Fatalf("WhichAreNew: expected none, got %v", newURLs)
+	}
+	if _, err = ss.AddURLs(testArts[1].ID+1000, extraURLs); err == nil {
+		t.Fatalf("AddURLs: expected error for missing article")
+	}
+
 	// Very basic FetchSummary() check
 	mustDay := func(s string) time.Time {
 		t, err := time.ParseInLocation("2006-01-02", s, time.UTC)
@@ -92,6 +122,55 @@ func performDBTests(t *testing.T, ss *SQLStore) {
 		t.Fatalf("FetchCount wrong (got %d, expected %d)",
 			cnt, len(testArts))
 	}
+
+	checkRuns(t, ss)
+}
+
+func checkRuns(t *testing.T, ss *SQLStore) {
+	start := time.Date(2019, 4, 1, 10, 0, 0, 0, time.UTC)
+	runs := []*store.ScrapeRun{
+		{Scraper: "example", Started: start, Finished: start.Add(time.Minute), PagesFetched: 10, ArtsFound: 100, ArtsNew: 5, ArtsStashed: 4, ArtErrors: 1},
+		{Scraper: "other", Started: start.Add(time.Hour)},
+		{Scraper: "example", Started: start.Add(2 * time.Hour), AbortReason: "too many errors"},
+	}
+	for _, run := range runs {
+		id, err := ss.StashRun(run)
+		if err != nil {
+			t.Fatalf("StashRun failed: %s", err)
+		}
+		run.ID = id
+	}
+
+	// update the last one
+	runs[2].Finished = start.Add(3 * time.Hour)
+	if _, err := ss.StashRun(runs[2]); err != nil {
+		t.Fatalf("StashRun (update) failed: %s", err)
+	}
+
+	got, err := ss.FetchRuns("example", 0)
+	if err != nil {
+		t.Fatalf("FetchRuns failed: %s", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("FetchRuns: wrong count (got %d, expected 2)", len(got))
+	}
+	// newest first
+	for i, expect := range []*store.ScrapeRun{runs[2], runs[0]} {
+		if got[i].ID != expect.ID || !got[i].Started.Equal(expect.Started) ||
+			!got[i].Finished.Equal(expect.Finished) ||
+			got[i].ArtsStashed != expect.ArtsStashed ||
+			got[i].AbortReason != expect.AbortReason {
+			t.Fatalf("FetchRuns: mismatch (got %+v, expected %+v)", got[i], *expect)
+		}
+	}
+
+	got, err = ss.FetchRuns("", 1)
+	if err != nil {
+		t.Fatalf("FetchRuns failed: %s", err)
+	}
+	if len(got) != 1 || got[0].ID != runs[2].ID {
+		t.Fatalf("FetchRuns: expected latest run only, got %+v", got)
+	}
 }
 
 func checkArticles(t *testing.T, ss *SQLStore, testArts []*store.Article) {
@@ -133,6 +133,60 @@ func (ss *SQLStore) stashArticle(tx *sql.Tx, art *store.Article) (int, error) {
 	return artID, nil
 }
 
+// AddURLs attaches extra URLs to an existing article, skipping any it
+// already has.
+// Returns the number of URLs added.
+func (ss *SQLStore) AddURLs(artID int, urls []string) (int, error) {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	cnt, err := ss.addURLs(tx, artID, urls)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
+
+func (ss *SQLStore) addURLs(tx *sql.Tx, artID int, urls []string) (int, error) {
+	var n int
+	err := tx.QueryRow(ss.rebind(`SELECT COUNT(*) FROM article WHERE id=?`), artID).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("no article with id %d", artID)
+	}
+
+	cnt := 0
+	seen := map[string]struct{}{}
+	for _, u := range urls {
+		if _, got := seen[u]; got || u == "" {
+			continue
+		}
+		seen[u] = struct{}{}
+		err = tx.QueryRow(ss.rebind(`SELECT COUNT(*) FROM article_url WHERE article_id=? AND url=?`), artID, u).Scan(&n)
+		if err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			continue // already got it
+		}
+		_, err = tx.Exec(ss.rebind(`INSERT INTO article_url(article_id,url) VALUES(?,?)`), artID, u)
+		if err != nil {
+			return 0, fmt.Errorf("failed adding url %s: %s", u, err)
+		}
+		cnt++
+	}
+	return cnt, nil
+}
+
 // addAuthorToArticle adds new rows to `author` and `author_attr`.
 func (ss *SQLStore) addAuthorToArticle(tx *sql.Tx, artID int, author *store.Author) error {
 	var authorID int
@@ -20,9 +20,30 @@ type DatePubCount struct {
 	Count   int
 }
 
+// ScrapeRun holds the details of a single scraper run.
+type ScrapeRun struct {
+	ID      int
+	Scraper string
+	// Started and Finished give the time span of the run
+	Started  time.Time
+	Finished time.Time
+	// PagesFetched and PageErrors count nav pages fetched during discovery
+	PagesFetched int
+	PageErrors   int
+	// ArtsFound is the number of article URLs found, ArtsNew the number
+	// not already in the store
+	ArtsFound   int
+	ArtsNew     int
+	ArtsStashed int
+	ArtErrors   int
+	// AbortReason is empty if the run completed normally
+	AbortReason string
+}
+
 type Store interface {
 	Close()
 	Stash(arts ...*Article) ([]int, error)
+	AddURLs(artID int, urls []string) (int, error)
 	WhichAreNew(artURLs []string) ([]string, error)
 	FindURLs(urls []string) ([]int, error)
 	FetchCount(filt *Filter) (int, error)
@@ -30,12 +51,14 @@ type Store interface {
 	FetchPublications() ([]Publication, error)
 	FetchSummary(filt *Filter, group string) ([]DatePubCount, error)
 	FetchArt(artID int) (*Article, error)
+	StashRun(run *ScrapeRun) (int, error)
+	FetchRuns(scraper string, count int) ([]ScrapeRun, error)
 }
 
 // TODO:
 // Need a cleaner definition of what's happening when we Stash articles.
-// For example, there's currently no simple way to add additional URLs to
-// an existing article.
+// (AddURLs() covers the common case of finding an already-stored article
+// under a new URL)
 //
 // The common case we should optimise for:
 // We have a bunch of scraped articles. We don't know if they are in the